@@ -0,0 +1,109 @@
+package dmrgo
+
+// Appending to the output of a previous run
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+)
+
+// append the reducer output to the output of previous runs, rather than replacing it.
+// Output files are then named without a run id, so each run finds the last one's.
+// If the job is a ValueMerger, values for keys already in the output are merged
+// with the new ones and the output rewritten; the merged values must be in the
+// same form as the reducer's output values.  Otherwise the new output is simply
+// appended, and keys seen in more than one run will appear more than once.
+var optAppend bool
+
+// readPriorOutput reads the key/value pairs from a previous run's output file.
+// A missing file is the same as an empty one.
+func readPriorOutput(fname string) (map[string]string, error) {
+
+	prior := make(map[string]string)
+
+	r, err := openMaybeCompressed(fname)
+	if os.IsNotExist(err) {
+		return prior, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+
+	for {
+		kv, err := ReadKeyValue(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		prior[kv.Key] = kv.Value
+	}
+
+	return prior, nil
+}
+
+// appendMerger merges the reducer output with the output of a previous run.
+// Keys in both have their values merged; the rest are passed through.  Keys
+// only in the previous output are emitted in order among the new ones, so the
+// output stays sorted if the reducer emits its keys in order.
+type appendMerger struct {
+	Emitter
+	merger ValueMerger
+	prior  map[string]string
+
+	// the previous output's keys, sorted, and the next one to consider emitting
+	keys []string
+	next int
+}
+
+func newAppendMerger(e Emitter, merger ValueMerger, prior map[string]string) *appendMerger {
+
+	keys := make([]string, 0, len(prior))
+	for k := range prior {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return &appendMerger{Emitter: e, merger: merger, prior: prior, keys: keys}
+}
+
+func (m *appendMerger) Emit(key string, value string) {
+
+	// first the previous output's keys which sort before this one
+	for m.next < len(m.keys) && m.keys[m.next] < key {
+		m.emitPriorKey(m.keys[m.next])
+		m.next++
+	}
+
+	if p, ok := m.prior[key]; ok {
+		value = m.merger.MergeValues(p, value)
+		delete(m.prior, key)
+	}
+	m.Emitter.Emit(key, value)
+}
+
+// emitPriorKey emits the previous output for the key, unless it's been merged already
+func (m *appendMerger) emitPriorKey(key string) {
+	if v, ok := m.prior[key]; ok {
+		m.Emitter.Emit(key, v)
+		delete(m.prior, key)
+	}
+}
+
+// emitPrior emits, in key order, the rest of the previous output for keys this run didn't produce
+func (m *appendMerger) emitPrior() {
+
+	for ; m.next < len(m.keys); m.next++ {
+		m.emitPriorKey(m.keys[m.next])
+	}
+
+	m.prior = nil
+}
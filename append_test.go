@@ -0,0 +1,49 @@
+package dmrgo
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+
+	setFlag(t, "append", "true")
+
+	res, _, err := runLocal(t, sumJob{}, []string{"b d d\n"}, &RunOptions{Partitions: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := res.Outputs[0]
+	if output != "red-out.0000" {
+		t.Errorf("with -append, the output is %q, want a name without a run id", output)
+	}
+
+	run := func(mrjob MapReduceJob, input string) string {
+		t.Helper()
+
+		if err := ioutil.WriteFile("input-next", []byte(input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := RunLocalFiles(mrjob, []string{"input-next"}, &RunOptions{Partitions: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// with a ValueMerger, keys in both runs are merged, and keys from either
+	// run alone keep their place in the sorted output
+	if got, want := run(sumJob{}, "a b c e\n"), "a\t1\nb\t2\nc\t1\nd\t2\ne\t1\n"; got != want {
+		t.Errorf("merged output %q, want %q", got, want)
+	}
+
+	// without one, the new output is added to the end, duplicating keys
+	if got, want := run(wordJob{}, "a\n"), "a\t1\nb\t2\nc\t1\nd\t2\ne\t1\na\t1\n"; got != want {
+		t.Errorf("appended output %q, want %q", got, want)
+	}
+}
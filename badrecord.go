@@ -0,0 +1,32 @@
+package dmrgo
+
+// Policy for handling bad input records
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"os"
+)
+
+// what should we do when we find a bad record: log, count or fail
+var optOnBadRecord string
+
+// badRecord handles a bad record according to the -on-bad-record policy.
+// counter names the dmrgo counter to increment in 'count' mode.  In 'fail'
+// mode the run in progress is stopped, as for -fail-fast, so its temporary
+// files are removed and it exits non-zero; outside a run, the record is only
+// logged.
+func badRecord(counter string, format string, a ...interface{}) {
+
+	switch optOnBadRecord {
+	case "count":
+		IncrCounter("dmrgo", counter, 1)
+	case "fail":
+		err := fmt.Errorf("bad record: "+format, a...)
+		fmt.Fprintln(os.Stderr, err)
+		stopCurrentRun(err)
+	default:
+		fmt.Fprintf(os.Stderr, "bad record: "+format+"\n", a...)
+	}
+}
@@ -0,0 +1,70 @@
+package dmrgo
+
+// Checksums for intermediate map output
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"strings"
+)
+
+// checksum algorithm for intermediate files, if any
+var optChecksum string
+
+var checksums = map[string]func() hash.Hash32{
+	"adler32": adler32.New,
+	"crc32c":  func() hash.Hash32 { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+}
+
+func checksumLine(h hash.Hash32, key, value string) uint32 {
+	h.Reset()
+	h.Write([]byte(key))
+	h.Write([]byte{'\t'})
+	h.Write([]byte(value))
+	return h.Sum32()
+}
+
+// checksumEmitter appends a checksum of each key/value pair to the value
+type checksumEmitter struct {
+	Emitter
+	h hash.Hash32
+}
+
+func (e *checksumEmitter) Emit(key string, value string) {
+	e.Emitter.Emit(key, fmt.Sprintf("%s\t%08x", value, checksumLine(e.h, key, value)))
+}
+
+// checksumReader verifies and removes the checksums added by checksumEmitter,
+// as the reducer reads its sorted input.  It checks the key as it was written,
+// before any KeyNormalizer sees it.  A record which fails verification is
+// counted, and ends the input with an error, so the partition fails rather
+// than its output being silently incomplete.
+type checksumReader struct {
+	r RecordReader
+	h hash.Hash32
+}
+
+func (c *checksumReader) ReadRecord() (*KeyValue, error) {
+
+	kv, err := c.r.ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	i := strings.LastIndex(kv.Value, "\t")
+	if i != -1 {
+		var sum uint32
+		_, err := fmt.Sscanf(kv.Value[i+1:], "%08x", &sum)
+		if err == nil && sum == checksumLine(c.h, kv.Key, kv.Value[:i]) {
+			return &KeyValue{kv.Key, kv.Value[:i]}, nil
+		}
+	}
+
+	IncrCounter("dmrgo", "checksum failures", 1)
+
+	return nil, fmt.Errorf("checksum mismatch for key %q", kv.Key)
+}
@@ -0,0 +1,34 @@
+package dmrgo
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestChecksumMismatchFailsPartition(t *testing.T) {
+
+	// the stub sort corrupts b's value, leaving its checksum alone
+	corruptingSort := writeScript(t, "corrupting-sort", "out=$1; shift; sort \"$@\" | sed 's/^b\\t1\\t/b\\t2\\t/' > \"$out\"\n")
+
+	setFlag(t, "sort-cmd", corruptingSort+" {output} {inputs}")
+	setFlag(t, "checksum", "crc32c")
+
+	res, lines, err := runLocal(t, wordJob{}, []string{"a b c\n"}, &RunOptions{Partitions: 1})
+
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("the corrupted partition gave error %v, want a checksum mismatch", err)
+	}
+
+	if n := res.Counters["dmrgo"]["checksum failures"]; n != 1 {
+		t.Errorf("checksum failures=%d, want 1", n)
+	}
+
+	// the partition's output isn't committed
+	if len(lines) != 0 {
+		t.Errorf("the failed partition output %q", lines)
+	}
+	if _, err := os.Stat(res.Outputs[0]); !os.IsNotExist(err) {
+		t.Errorf("the failed partition's output file's stat gave %v, want it missing", err)
+	}
+}
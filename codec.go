@@ -0,0 +1,225 @@
+package dmrgo
+
+// Compression codecs for input and output files
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Codec compresses and decompresses streams
+type Codec interface {
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// ErrNoWriter is returned by codecs which can only decompress
+var ErrNoWriter = errors.New("dmrgo: codec does not support compression")
+
+// GzipCodec handles gzip-compressed files
+type GzipCodec struct{}
+
+// NewReader implements the Codec interface
+func (GzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+
+// NewWriter implements the Codec interface
+func (GzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+
+// Bzip2Codec handles bzip2-compressed files.  It can only decompress.
+type Bzip2Codec struct{}
+
+// NewReader implements the Codec interface
+func (Bzip2Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bzip2.NewReader(r)), nil
+}
+
+// NewWriter implements the Codec interface
+func (Bzip2Codec) NewWriter(w io.Writer) (io.WriteCloser, error) { return nil, ErrNoWriter }
+
+// the registered codecs, by file extension
+var codecsMu sync.RWMutex
+var codecs = map[string]Codec{
+	".gz":  GzipCodec{},
+	".bz2": Bzip2Codec{},
+}
+
+// RegisterCodec makes a codec available for files with the given extension (e.g. ".zst").
+// It panics if a codec for the extension is already registered.
+func RegisterCodec(ext string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, dup := codecs[ext]; dup {
+		panic("dmrgo: RegisterCodec called twice for " + ext)
+	}
+	codecs[ext] = c
+}
+
+func codecFor(ext string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[ext]
+}
+
+// extension of the codec used to compress the reducer output, if any
+var optCompress string
+
+// checkCompress checks the -compress codec exists and can compress, so a run
+// doesn't do all its map work only to fail creating the output
+func checkCompress() error {
+
+	if optCompress == "" {
+		return nil
+	}
+
+	c := codecFor(optCompress)
+	if c == nil {
+		return errors.New("unknown codec for -compress: " + optCompress)
+	}
+
+	w, err := c.NewWriter(ioutil.Discard)
+	if err != nil {
+		return fmt.Errorf("can't compress with -compress %s: %v", optCompress, err)
+	}
+
+	return w.Close()
+}
+
+// a decompressing reader that also closes the underlying file
+type codecReader struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (r *codecReader) Close() error {
+	err := r.ReadCloser.Close()
+	if ferr := r.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// openMaybeCompressed opens fname, decompressing it if its extension has a registered codec
+func openMaybeCompressed(fname string) (io.ReadCloser, error) {
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	c := codecFor(filepath.Ext(fname))
+	if c == nil {
+		return f, nil
+	}
+
+	r, err := c.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &codecReader{r, f}, nil
+}
+
+// streamInput returns the input for the streaming mapper or reducer: the files
+// named on the command line, one after another and decompressed if their
+// extension has a codec, or stdin.  Gzip-compressed stdin is decompressed too.
+// The returned function closes any files opened.
+func streamInput(fnames []string) (io.Reader, func(), error) {
+
+	if len(fnames) == 0 {
+		br := bufio.NewReader(os.Stdin)
+		if magic, _ := br.Peek(2); string(magic) == "\x1f\x8b" {
+			r, err := gzip.NewReader(br)
+			if err != nil {
+				return nil, nil, err
+			}
+			return r, func() {}, nil
+		}
+		return br, func() {}, nil
+	}
+
+	var files []io.ReadCloser
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	readers := make([]io.Reader, len(fnames))
+	for i, fname := range fnames {
+		f, err := openMaybeCompressed(fname)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		readers[i] = f
+	}
+
+	return io.MultiReader(readers...), closeAll, nil
+}
+
+// a compressing writer that also closes the underlying file
+type codecWriter struct {
+	io.WriteCloser
+	f *os.File
+}
+
+func (w *codecWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if ferr := w.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// createMaybeCompressed creates fname, compressing it with the -compress codec if one was given
+func createMaybeCompressed(fname string) (io.WriteCloser, error) {
+	return openWriterMaybeCompressed(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// appendMaybeCompressed opens fname for appending, creating it if needed.
+// A compressed file gets a new stream appended; gzip readers read them all.
+func appendMaybeCompressed(fname string) (io.WriteCloser, error) {
+	return openWriterMaybeCompressed(fname, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+}
+
+func openWriterMaybeCompressed(fname string, flag int) (io.WriteCloser, error) {
+
+	var c Codec
+	if optCompress != "" {
+		if c = codecFor(optCompress); c == nil {
+			return nil, errors.New("dmrgo: unknown codec " + optCompress)
+		}
+	}
+
+	f, err := os.OpenFile(fname, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	if c == nil {
+		return f, nil
+	}
+
+	w, err := c.NewWriter(f)
+	if err != nil {
+		f.Close()
+		if flag&os.O_APPEND == 0 {
+			os.Remove(fname)
+		}
+		return nil, err
+	}
+
+	return &codecWriter{w, f}, nil
+}
@@ -0,0 +1,122 @@
+package dmrgo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReducerCompressedInput(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// a sorted map output, split across a gzipped file and a plain one
+	gz := filepath.Join(dir, "part-0.gz")
+	plain := filepath.Join(dir, "part-1")
+	if err := ioutil.WriteFile(gz, gzipped(t, "a\t1\na\t2\nb\t1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(plain, []byte("b\t2\nc\t1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, closeInput, err := streamInput([]string{gz, plain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e SliceEmitter
+	reducer(wordJob{}, r, &e)
+	closeInput()
+
+	want := []KeyValue{{"a", "3"}, {"b", "3"}, {"c", "1"}}
+	if !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced %v, want %v", e.KeyValues, want)
+	}
+
+	// gzipped stdin is recognized by its magic number
+	stdin := filepath.Join(dir, "stdin")
+	if err := ioutil.WriteFile(stdin, gzipped(t, "a\t1\na\t2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	oldStdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = oldStdin }()
+
+	r, closeInput, err = streamInput(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e = SliceEmitter{}
+	reducer(wordJob{}, r, &e)
+	closeInput()
+
+	if want := []KeyValue{{"a", "3"}}; !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced gzipped stdin to %v, want %v", e.KeyValues, want)
+	}
+
+	// a missing file is an error
+	if _, _, err := streamInput([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("streamInput of a missing file succeeded")
+	}
+}
+
+func TestCompressNeedsWriter(t *testing.T) {
+
+	for _, ext := range []string{".bz2", ".no-such-codec"} {
+		setFlag(t, "compress", ext)
+
+		var maps int
+		_, _, err := runLocal(t, countingJob{maps: &maps}, []string{"a b\n"}, nil)
+
+		if err == nil || !strings.Contains(err.Error(), ext) {
+			t.Errorf("-compress %s gave error %v, want one naming the codec", ext, err)
+		}
+		if maps != 0 {
+			t.Errorf("-compress %s: Map was called %d times before the codec was checked", ext, maps)
+		}
+	}
+
+	setFlag(t, "compress", ".gz")
+
+	res, _, err := runLocal(t, wordJob{}, []string{"a b\n"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openMaybeCompressed(res.Outputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil || string(b) != "a\t1\nb\t1\n" {
+		t.Errorf("-compress .gz output read back as %q, %v", b, err)
+	}
+}
@@ -0,0 +1,155 @@
+package dmrgo
+
+// Merging map output values by key before the shuffle
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"container/list"
+)
+
+// ValueMerger can be implemented by jobs whose map output values for the same key can be merged
+// before the shuffle, such as counts which can be summed.  In standalone map/reduce mode, the
+// mapper output is then passed through a CombiningEmitter.
+type ValueMerger interface {
+	MergeValues(a, b string) string
+}
+
+// how many keys the combining emitter holds before spilling
+var optMergeKeys int
+
+// also merge values as the sorted reducer input is read
+var optCombineOnMerge bool
+
+type combineEntry struct {
+	key   string
+	value string
+}
+
+// CombiningEmitter merges the values of identical keys before passing them on to another Emitter.
+// At most MaxKeys keys are held; when full, the least-recently-used key is spilled.
+type CombiningEmitter struct {
+	e       Emitter
+	merge   func(a, b string) string
+	maxKeys int
+
+	keys map[string]*list.Element
+	lru  *list.List
+
+	// Spills is the number of keys emitted to make room for new keys
+	Spills int
+}
+
+// NewCombiningEmitter returns a CombiningEmitter which uses merge to combine values and holds at most maxKeys keys
+func NewCombiningEmitter(e Emitter, merge func(a, b string) string, maxKeys int) *CombiningEmitter {
+	if maxKeys < 1 {
+		maxKeys = 1
+	}
+	return &CombiningEmitter{
+		e:       e,
+		merge:   merge,
+		maxKeys: maxKeys,
+		keys:    make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Emit implements the Emitter interface
+func (c *CombiningEmitter) Emit(key string, value string) {
+
+	if elt, ok := c.keys[key]; ok {
+		ent := elt.Value.(*combineEntry)
+		ent.value = c.merge(ent.value, value)
+		c.lru.MoveToFront(elt)
+		return
+	}
+
+	if c.lru.Len() >= c.maxKeys {
+		oldest := c.lru.Back()
+		ent := c.lru.Remove(oldest).(*combineEntry)
+		delete(c.keys, ent.key)
+		c.e.Emit(ent.key, ent.value)
+		c.Spills++
+	}
+
+	c.keys[key] = c.lru.PushFront(&combineEntry{key, value})
+}
+
+// EmitAll passes the pair straight through, uncombined: with EmitAll if the
+// underlying Emitter is a BroadcastEmitter, otherwise with Emit
+func (c *CombiningEmitter) EmitAll(key string, value string) {
+	if be, ok := c.e.(BroadcastEmitter); ok {
+		be.EmitAll(key, value)
+	} else {
+		c.e.Emit(key, value)
+	}
+}
+
+// Flush emits all held keys, reports the number of spills, and flushes the underlying Emitter
+func (c *CombiningEmitter) Flush() {
+
+	for elt := c.lru.Back(); elt != nil; elt = elt.Prev() {
+		ent := elt.Value.(*combineEntry)
+		c.e.Emit(ent.key, ent.value)
+	}
+
+	c.keys = make(map[string]*list.Element)
+	c.lru.Init()
+
+	if c.Spills > 0 {
+		IncrCounter("dmrgo", "combiner spills", c.Spills)
+		c.Spills = 0
+	}
+
+	c.e.Flush()
+}
+
+// combiningRecordReader merges the values of adjacent records with the same key,
+// so sorted reducer input reaches Reduce already combined.
+type combiningRecordReader struct {
+	r     RecordReader
+	merge func(a, b string) string
+
+	// the record read past the end of the current key, or the error which ended the input
+	next *KeyValue
+	err  error
+}
+
+func (c *combiningRecordReader) ReadRecord() (*KeyValue, error) {
+
+	if c.next == nil && c.err == nil {
+		c.next, c.err = c.r.ReadRecord()
+	}
+
+	if c.next == nil {
+		return nil, c.err
+	}
+
+	kv := *c.next
+
+	for {
+		next, err := c.r.ReadRecord()
+		if err != nil {
+			c.next, c.err = nil, err
+			return &kv, nil
+		}
+		if next.Key != kv.Key {
+			c.next = next
+			return &kv, nil
+		}
+		kv.Value = c.merge(kv.Value, next.Value)
+	}
+}
+
+// mapperEmitter wraps the mapper's output emitter in a CombiningEmitter, if the job can merge values,
+// and in a tee to stderr, if requested.  Jobs wanting their values in emit order
+// have sequence numbers added instead, and aren't combined.
+func mapperEmitter(mrjob MapReduceJob, pe Emitter) Emitter {
+	if isStable(mrjob) {
+		return teeEmitter(mapperStable(mrjob, pe))
+	}
+	if m, ok := mrjob.(ValueMerger); ok {
+		return teeEmitter(NewCombiningEmitter(pe, m.MergeValues, optMergeKeys))
+	}
+	return teeEmitter(pe)
+}
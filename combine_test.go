@@ -0,0 +1,78 @@
+package dmrgo
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// sumJob is wordJob with its counts merged before the reducer
+type sumJob struct {
+	wordJob
+}
+
+func (sumJob) MergeValues(a, b string) string {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return strconv.Itoa(x + y)
+}
+
+func TestCombiningRecordReader(t *testing.T) {
+
+	kvs := []KeyValue{{"", "1"}, {"a", "1"}, {"a", "2"}, {"a", "3"}, {"b", "1"}, {"c", "4"}, {"c", "5"}}
+
+	var plain, combined SliceEmitter
+
+	reduceRecords(sumJob{}, &sliceRecordReader{append([]KeyValue(nil), kvs...)}, &plain)
+	reduceRecords(sumJob{}, &combiningRecordReader{r: &sliceRecordReader{kvs}, merge: sumJob{}.MergeValues}, &combined)
+
+	if !reflect.DeepEqual(plain.KeyValues, combined.KeyValues) {
+		t.Errorf("combined while merging gave %v, but reducing gave %v", combined.KeyValues, plain.KeyValues)
+	}
+
+	// and the same end-to-end
+	input := "a b a\nc a b\n"
+
+	_, want, err := runLocal(t, sumJob{}, []string{input, input}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, "combine-on-merge", "true")
+
+	_, got, err := runLocal(t, sumJob{}, []string{input, input}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("with -combine-on-merge got %q, without %q", got, want)
+	}
+}
+
+func TestCombiningEmitter(t *testing.T) {
+
+	quietStderr(t)
+
+	var e SliceEmitter
+	c := NewCombiningEmitter(&e, sumJob{}.MergeValues, 2)
+
+	c.Emit("a", "1")
+	c.Emit("b", "2")
+	c.Emit("a", "3")
+	c.Emit("c", "1") // spills "b", the least recently used
+
+	// EmitAll skips the combiner, and falls back to Emit for emitters which can't broadcast
+	c.EmitAll("all", "1")
+
+	if c.Spills != 1 {
+		t.Errorf("%d spills, want 1", c.Spills)
+	}
+
+	c.Flush()
+
+	want := []KeyValue{{"b", "2"}, {"all", "1"}, {"a", "4"}, {"c", "1"}}
+	if !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("combined to %v, want %v", e.KeyValues, want)
+	}
+}
@@ -0,0 +1,71 @@
+package dmrgo
+
+// Job configuration properties, like Hadoop's -D
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// properties set with -D key=value
+var confMu sync.RWMutex
+var conf = make(map[string]string)
+
+// confFlag is a flag.Value which collects repeated -D key=value flags
+type confFlag struct{}
+
+func (confFlag) String() string { return "" }
+
+func (confFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return errors.New("expected key=value")
+	}
+	confMu.Lock()
+	conf[s[:i]] = s[i+1:]
+	confMu.Unlock()
+	return nil
+}
+
+// ConfGet returns the configuration property key.  Properties set with -D take
+// priority; otherwise, as with Hadoop streaming, the property is looked up in
+// the environment with the dots in its name replaced by underscores.  If the
+// property isn't set, def is returned.
+func ConfGet(key, def string) string {
+
+	confMu.RLock()
+	v, ok := conf[key]
+	confMu.RUnlock()
+	if ok {
+		return v
+	}
+
+	if v, ok := os.LookupEnv(strings.Replace(key, ".", "_", -1)); ok {
+		return v
+	}
+
+	return def
+}
+
+// ConfGetInt returns the configuration property key as an int, or def if it isn't set or isn't an int
+func ConfGetInt(key string, def int) int {
+	i, err := strconv.Atoi(ConfGet(key, ""))
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// ConfGetBool returns the configuration property key as a bool, or def if it isn't set or isn't a bool
+func ConfGetBool(key string, def bool) bool {
+	b, err := strconv.ParseBool(ConfGet(key, ""))
+	if err != nil {
+		return def
+	}
+	return b
+}
@@ -0,0 +1,62 @@
+package dmrgo
+
+// Reducing to distinct keys or values
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"strconv"
+)
+
+// DistinctMode selects what a DistinctReducer emits
+type DistinctMode int
+
+const (
+	// DistinctKeys emits each key once, with an empty value
+	DistinctKeys DistinctMode = iota
+
+	// DistinctValues emits each key with the exact number of distinct values
+	DistinctValues
+
+	// ApproxDistinctValues emits each key with an estimate of the number of
+	// distinct values, from a HyperLogLog.  Memory use is bounded for any
+	// number of values.
+	ApproxDistinctValues
+)
+
+// DistinctReducer is a Reduce for the common "distinct keys" and "count distinct
+// values per key" jobs.  Embed it in a job to get the Reduce method.
+type DistinctReducer struct {
+	Mode DistinctMode
+
+	// Precision of the HyperLogLog for ApproxDistinctValues.  If 0, 14 is used.
+	Precision uint8
+}
+
+// Reduce implements the MapReduceJob interface
+func (d *DistinctReducer) Reduce(key string, values []string, emitter Emitter) {
+
+	switch d.Mode {
+
+	case DistinctKeys:
+		emitter.Emit(key, "")
+
+	case DistinctValues:
+		seen := make(map[string]struct{})
+		for _, v := range values {
+			seen[v] = struct{}{}
+		}
+		emitter.Emit(key, strconv.Itoa(len(seen)))
+
+	case ApproxDistinctValues:
+		precision := d.Precision
+		if precision == 0 {
+			precision = 14
+		}
+		h := NewHyperLogLog(precision)
+		for _, v := range values {
+			h.Add(v)
+		}
+		emitter.Emit(key, strconv.FormatUint(h.Count(), 10))
+	}
+}
@@ -0,0 +1,40 @@
+package dmrgo
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestDistinctReducer(t *testing.T) {
+
+	// 3000 distinct values, each seen several times
+	var values []string
+	for i := 0; i < 9000; i++ {
+		values = append(values, fmt.Sprint(i%3000))
+	}
+
+	var e SliceEmitter
+
+	(&DistinctReducer{Mode: DistinctKeys}).Reduce("k", values, &e)
+	(&DistinctReducer{Mode: DistinctValues}).Reduce("k", values, &e)
+	(&DistinctReducer{Mode: ApproxDistinctValues}).Reduce("k", values, &e)
+
+	if len(e.KeyValues) != 3 {
+		t.Fatalf("emitted %v, want one pair per mode", e.KeyValues)
+	}
+
+	if kv := e.KeyValues[0]; kv != (KeyValue{"k", ""}) {
+		t.Errorf("DistinctKeys emitted %v, want the key with an empty value", kv)
+	}
+
+	if kv := e.KeyValues[1]; kv != (KeyValue{"k", "3000"}) {
+		t.Errorf("DistinctValues emitted %v, want 3000", kv)
+	}
+
+	// the default precision has about 0.8% standard error; allow four of them
+	n, err := strconv.Atoi(e.KeyValues[2].Value)
+	if err != nil || n < 2900 || n > 3100 {
+		t.Errorf("ApproxDistinctValues emitted %v, want about 3000", e.KeyValues[2])
+	}
+}
@@ -0,0 +1,142 @@
+package dmrgo
+
+// Inspecting intermediate files
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// DumpPartition writes a summary of an intermediate file to w, for debugging:
+// the first and last n records (none if n is 0), with a line marking where
+// each new key group starts, and the number of records, key groups and unique
+// keys.  In a sorted file, such as a reducer's input, there are as many groups
+// as unique keys.
+// The file is read in the -intermediate format, and keys and values are shown
+// as decoded by the intermediate protocol where it can decode them into
+// generic values, and as they are otherwise.  Compressed files are decompressed.
+func DumpPartition(path string, w io.Writer, n int) error {
+
+	f, err := openMaybeCompressed(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records RecordReader
+	if optIntermediate == "framed" {
+		records = &framedRecordReader{bufio.NewReader(f)}
+	} else {
+		records = &lineRecordReader{bufio.NewReader(f)}
+	}
+
+	var proto StreamProtocol
+	if ps, err := JobProtocols(); err == nil {
+		proto = ps.Intermediate
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	if n > 0 {
+		fmt.Fprintf(bw, "%s: first %d records\n", path, n)
+	}
+
+	var total, groups int
+	var prevKey string
+	keys := make(map[string]struct{})
+
+	// the last n records, and whether each started a group
+	type dumped struct {
+		kv       KeyValue
+		newGroup bool
+	}
+	last := make([]dumped, 0, n)
+
+	for {
+		kv, err := records.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if kv.Key == protoHeaderKey {
+			continue
+		}
+
+		newGroup := total == 0 || kv.Key != prevKey
+		if newGroup {
+			groups++
+		}
+		prevKey = kv.Key
+		keys[kv.Key] = struct{}{}
+
+		if total < n {
+			dumpRecord(bw, proto, *kv, newGroup)
+		}
+
+		if n > 0 {
+			if len(last) == n {
+				copy(last, last[1:])
+				last = last[:n-1]
+			}
+			last = append(last, dumped{*kv, newGroup})
+		}
+
+		total++
+	}
+
+	if n > 0 && total > n {
+		fmt.Fprintf(bw, "%s: last %d records\n", path, len(last))
+		for _, d := range last {
+			dumpRecord(bw, proto, d.kv, d.newGroup)
+		}
+	}
+
+	fmt.Fprintf(bw, "%s: %d records, %d key groups, %d unique keys\n", path, total, groups, len(keys))
+
+	return nil
+}
+
+// dumpRecord writes a record for DumpPartition, decoded if the protocol can
+func dumpRecord(w io.Writer, proto StreamProtocol, kv KeyValue, newGroup bool) {
+
+	if newGroup {
+		fmt.Fprintln(w, "--")
+	}
+
+	k, v := decodeForDump(proto, kv)
+	fmt.Fprintf(w, "%v\t%v\n", k, v)
+}
+
+// decodeForDump decodes the record into generic values, or returns it quoted
+// if the protocol can't decode it into them
+func decodeForDump(proto StreamProtocol, kv KeyValue) (k interface{}, v interface{}) {
+
+	k, v = fmt.Sprintf("%q", kv.Key), fmt.Sprintf("%q", kv.Value)
+
+	if proto == nil {
+		return k, v
+	}
+
+	// protocols may panic on values they can't represent generically
+	defer func() { recover() }()
+
+	var dk interface{}
+	var dvs []interface{}
+	proto.UnmarshalKVs(kv.Key, []string{kv.Value}, &dk, &dvs)
+
+	if dk != nil {
+		k = dk
+	}
+	if len(dvs) == 1 && dvs[0] != nil {
+		v = dvs[0]
+	}
+
+	return k, v
+}
@@ -0,0 +1,54 @@
+package dmrgo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpPartition(t *testing.T) {
+
+	dir := t.TempDir()
+
+	fname := filepath.Join(dir, "red-in.0000")
+	input := "\"a\"\t1\n\"a\"\t2\n\"b\"\t3\n\"c\"\t4\nnot-json\t{\n"
+	if err := ioutil.WriteFile(fname, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := DumpPartition(fname, &buf, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// JSON records are decoded, the rest are quoted
+	want := fname + ": first 2 records\n" +
+		"--\na\t1\na\t2\n" +
+		fname + ": last 2 records\n" +
+		"--\nc\t4\n--\n\"not-json\"\t\"{\"\n" +
+		fname + ": 5 records, 4 key groups, 4 unique keys\n"
+
+	if got := buf.String(); got != want {
+		t.Errorf("dumped\n%s\nwant\n%s", got, want)
+	}
+
+	// an unsorted file has more groups than keys; with n 0, only the counts are shown
+	unsorted := filepath.Join(dir, "map-out.0000")
+	if err := ioutil.WriteFile(unsorted, []byte("\"a\"\t1\n\"b\"\t1\n\"a\"\t1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := DumpPartition(unsorted, &buf, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), unsorted+": 3 records, 3 key groups, 2 unique keys\n"; got != want {
+		t.Errorf("dumped %q, want %q", got, want)
+	}
+
+	if err := DumpPartition(filepath.Join(dir, "missing"), &buf, 2); err == nil {
+		t.Errorf("dumping a missing file succeeded")
+	}
+}
@@ -6,17 +6,76 @@ package dmrgo
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"hash/adler32"
+	"io"
 	"os"
+	"sync"
+	"time"
 )
 
+// how often should the partition emitter flush its files (0 means only at the end)
+var optFlushRecords int
+var optFlushInterval time.Duration
+
+// start a new part of a partition's intermediate file once it reaches this many bytes (0 means never).
+// The parts are named after the file, with .part0, .part1, ... appended.
+var optRotateBytes int64
+
+// how often to retry creating an intermediate file, and how long to wait before the first retry.
+// The wait doubles after each retry.
+var optCreateRetries int
+var optCreateBackoff time.Duration
+
+// createWithRetry creates fname, retrying failures as -create-retries and -create-backoff say
+func createWithRetry(fname string) (*os.File, error) {
+
+	backoff := optCreateBackoff
+
+	for retry := 0; ; retry++ {
+		f, err := os.Create(fname)
+		if err == nil || retry >= optCreateRetries {
+			return f, err
+		}
+		IncrCounter("dmrgo", "create retries", 1)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// should emitted pairs also be written to stderr
+var optTee bool
+
 // Emitter emits key/value pairs
 type Emitter interface {
 	Emit(key string, value string)
 	Flush()
 }
 
+// BroadcastEmitter is implemented by emitters which can send a key/value pair
+// to every partition.  In standalone map/reduce mode, and with RunReaders, the
+// Emitter passed to Map is a BroadcastEmitter.
+type BroadcastEmitter interface {
+	EmitAll(key string, value string)
+}
+
+// BatchEmitter is implemented by emitters which can emit several key/value
+// pairs at once more cheaply than one at a time.
+type BatchEmitter interface {
+	EmitBatch(pairs []KeyValue)
+}
+
+// EmitBatch emits pairs with e's EmitBatch, if it's a BatchEmitter, or otherwise one at a time
+func EmitBatch(e Emitter, pairs []KeyValue) {
+	if be, ok := e.(BatchEmitter); ok {
+		be.EmitBatch(pairs)
+		return
+	}
+	for _, kv := range pairs {
+		e.Emit(kv.Key, kv.Value)
+	}
+}
+
 type printEmitter struct {
 	w *bufio.Writer
 }
@@ -28,22 +87,187 @@ func newPrintEmitter(w *bufio.Writer) *printEmitter {
 }
 
 func (e *printEmitter) Emit(key string, value string) {
-	e.w.WriteString(key)
-	e.w.WriteByte('\t')
-	e.w.WriteString(value)
-	e.w.WriteByte('\n')
+	WriteKeyValue(e.w, KeyValue{key, value})
+}
+
+func (e *printEmitter) EmitBatch(pairs []KeyValue) {
+	for _, kv := range pairs {
+		WriteKeyValue(e.w, kv)
+	}
 }
 
 func (e *printEmitter) Flush() {
 	e.w.Flush()
 }
 
+// SliceEmitter collects emitted key/value pairs in memory.  Useful for testing.
+type SliceEmitter struct {
+	KeyValues []KeyValue
+}
+
+// Emit implements the Emitter interface
+func (e *SliceEmitter) Emit(key string, value string) {
+	e.KeyValues = append(e.KeyValues, KeyValue{key, value})
+}
+
+// EmitBatch implements the BatchEmitter interface
+func (e *SliceEmitter) EmitBatch(pairs []KeyValue) {
+	e.KeyValues = append(e.KeyValues, pairs...)
+}
+
+// Flush implements the Emitter interface
+func (e *SliceEmitter) Flush() { /* nothing */
+}
+
+// MultiEmitter passes key/value pairs on to each of its emitters in turn
+type MultiEmitter struct {
+	emitters []Emitter
+}
+
+// NewMultiEmitter returns a MultiEmitter for the given emitters
+func NewMultiEmitter(emitters ...Emitter) *MultiEmitter {
+	m := new(MultiEmitter)
+	for _, e := range emitters {
+		// flatten nested MultiEmitters
+		if mm, ok := e.(*MultiEmitter); ok {
+			m.emitters = append(m.emitters, mm.emitters...)
+		} else {
+			m.emitters = append(m.emitters, e)
+		}
+	}
+	return m
+}
+
+// Emit implements the Emitter interface
+func (m *MultiEmitter) Emit(key string, value string) {
+	for _, e := range m.emitters {
+		e.Emit(key, value)
+	}
+}
+
+// EmitAll passes the pair on to every emitter: with EmitAll if it's a BroadcastEmitter, otherwise with Emit
+func (m *MultiEmitter) EmitAll(key string, value string) {
+	for _, e := range m.emitters {
+		if be, ok := e.(BroadcastEmitter); ok {
+			be.EmitAll(key, value)
+		} else {
+			e.Emit(key, value)
+		}
+	}
+}
+
+// EmitBatch implements the BatchEmitter interface
+func (m *MultiEmitter) EmitBatch(pairs []KeyValue) {
+	for _, e := range m.emitters {
+		EmitBatch(e, pairs)
+	}
+}
+
+// Flush implements the Emitter interface
+func (m *MultiEmitter) Flush() {
+	for _, e := range m.emitters {
+		e.Flush()
+	}
+}
+
+// ContextEmitter passes key/value pairs on to an emitter until its context is
+// cancelled, and then drops them, without flushing anything still buffered.
+// Emit can't return an error, so jobs which want to stop early should check Err.
+type ContextEmitter struct {
+	Emitter
+	ctx context.Context
+}
+
+// WithContext returns a ContextEmitter for e which stops when ctx is cancelled
+func WithContext(ctx context.Context, e Emitter) *ContextEmitter {
+	return &ContextEmitter{e, ctx}
+}
+
+// Emit implements the Emitter interface
+func (e *ContextEmitter) Emit(key string, value string) {
+	if e.ctx.Err() == nil {
+		e.Emitter.Emit(key, value)
+	}
+}
+
+// EmitAll implements the BroadcastEmitter interface, if the wrapped emitter does
+func (e *ContextEmitter) EmitAll(key string, value string) {
+	if e.ctx.Err() != nil {
+		return
+	}
+	if b, ok := e.Emitter.(BroadcastEmitter); ok {
+		b.EmitAll(key, value)
+	} else {
+		e.Emitter.Emit(key, value)
+	}
+}
+
+// Flush implements the Emitter interface
+func (e *ContextEmitter) Flush() {
+	if e.ctx.Err() == nil {
+		e.Emitter.Flush()
+	}
+}
+
+// Err returns the context's error once it has been cancelled, and nil before
+func (e *ContextEmitter) Err() error {
+	return e.ctx.Err()
+}
+
+// stderrEmitter writes key/value pairs to stderr, a line at a time.  It's safe for concurrent use.
+type stderrEmitter struct {
+	mu sync.Mutex
+	e  *printEmitter
+}
+
+func (s *stderrEmitter) Emit(key string, value string) {
+	s.mu.Lock()
+	s.e.Emit(key, value)
+	s.e.Flush()
+	s.mu.Unlock()
+}
+
+func (s *stderrEmitter) Flush() { /* nothing */
+}
+
+var teeStderr = &stderrEmitter{e: newPrintEmitter(bufio.NewWriter(os.Stderr))}
+
+// teeEmitter also sends e's key/value pairs to stderr, if -tee was given
+func teeEmitter(e Emitter) Emitter {
+	if !optTee {
+		return e
+	}
+	return NewMultiEmitter(e, teeStderr)
+}
+
 type partitionEmitter struct {
 	partitions       uint32
 	FileNames        []string
 	fds              []*os.File
 	emitters         []Emitter
 	fileNameTemplate string
+
+	// the run the files are for, if they're intermediate files
+	run *runState
+
+	// the first error creating a file
+	err error
+
+	// if set, chooses partitions instead of the standalone map/reduce's partitioner
+	p Partitioner
+
+	// are these intermediate files, counted against -max-temp-bytes and rotated by
+	// -rotate-bytes, and written as -intermediate, -checksum and -proto-header ask
+	temp bool
+
+	// all the files written for each partition, in order, and the size of the current one
+	parts [][]string
+	bufs  []*bufio.Writer
+	sizes []*countingWriter
+
+	// for periodic flushing
+	unflushed int
+	lastFlush time.Time
 }
 
 // data sink -- useful for benchmarking
@@ -54,33 +278,161 @@ func (*nullEmitter) Emit(key string, value string) { /* nothing */
 func (*nullEmitter) Flush() { /* nothing */
 }
 
-func newPartitionEmitter(partitions uint, template string) *partitionEmitter {
+func newPartitionEmitter(run *runState, partitions uint, template string) *partitionEmitter {
 	pe := new(partitionEmitter)
+	pe.run = run
 	pe.partitions = uint32(partitions)
 	pe.fileNameTemplate = template
 	pe.FileNames = make([]string, partitions)
 	pe.fds = make([]*os.File, partitions)
 	pe.emitters = make([]Emitter, partitions)
+	pe.parts = make([][]string, partitions)
+	pe.bufs = make([]*bufio.Writer, partitions)
+	pe.sizes = make([]*countingWriter, partitions)
+	pe.lastFlush = time.Now()
+	pe.temp = true
 	return pe
 }
 
 func (e *partitionEmitter) Emit(key string, value string) {
 
-	partition := uint32(0)
+	partition := e.partition(key)
+
+	e.emitter(partition).Emit(key, value)
+
+	e.maybeRotate(partition)
+	e.maybeFlush(1)
+}
+
+// EmitBatch groups the pairs by partition, and writes each group in one go
+func (e *partitionEmitter) EmitBatch(pairs []KeyValue) {
+
+	if e.partitions <= 1 {
+		EmitBatch(e.emitter(0), pairs)
+		e.maybeRotate(0)
+		e.maybeFlush(len(pairs))
+		return
+	}
+
+	groups := make([][]KeyValue, e.partitions)
+	for _, kv := range pairs {
+		partition := e.partition(kv.Key)
+		groups[partition] = append(groups[partition], kv)
+	}
+
+	for partition, group := range groups {
+		if len(group) > 0 {
+			EmitBatch(e.emitter(uint32(partition)), group)
+			e.maybeRotate(uint32(partition))
+		}
+	}
+
+	e.maybeFlush(len(pairs))
+}
+
+func (e *partitionEmitter) partition(key string) uint32 {
+	if e.p != nil {
+		return e.p.Partition(key, e.partitions)
+	}
+	return partitionOf(key, e.partitions)
+}
+
+// EmitAll writes the key/value pair to every partition.  This is meant for
+// broadcasting small datasets (such as the dimension table of a replicated
+// join): the pair is written once per partition, so the disk space and IO
+// used, and the memory needed by every reducer that loads it, grows with the
+// number of partitions.
+func (e *partitionEmitter) EmitAll(key string, value string) {
+	for partition := uint32(0); partition < e.partitions; partition++ {
+		e.emitter(partition).Emit(key, value)
+		e.maybeRotate(partition)
+	}
+
+	e.maybeFlush(1)
+}
+
+// flush all partitions if -flush-records or -flush-interval says it's time
+func (e *partitionEmitter) maybeFlush(records int) {
+
+	e.unflushed += records
 
-	if e.partitions > 1 {
-		partition = adler32.Checksum([]byte(key)) % uint32(e.partitions)
+	if (optFlushRecords > 0 && e.unflushed >= optFlushRecords) ||
+		(optFlushInterval > 0 && time.Since(e.lastFlush) >= optFlushInterval) {
+		e.Flush()
 	}
+}
+
+// return the emitter for the partition, opening its file if needed
+func (e *partitionEmitter) emitter(partition uint32) Emitter {
 
 	if e.emitters[partition] == nil {
 		e.FileNames[partition] = fmt.Sprintf("%s.%04d", e.fileNameTemplate, partition)
-		fd, _ := os.Create(e.FileNames[partition])
+		if e.temp && optRotateBytes > 0 {
+			e.FileNames[partition] += fmt.Sprintf(".part%d", len(e.parts[partition]))
+		}
+		e.parts[partition] = append(e.parts[partition], e.FileNames[partition])
+		fd, err := createWithRetry(e.FileNames[partition])
+		if err != nil {
+			// the partition's output would be silently incomplete, so fail it,
+			// and drop what's emitted to it
+			fmt.Fprintln(os.Stderr, "err creating file: ", err)
+			if e.err == nil {
+				e.err = err
+			}
+			if e.run != nil {
+				e.run.fail(err)
+			}
+			e.parts[partition] = e.parts[partition][:len(e.parts[partition])-1]
+			e.FileNames[partition] = ""
+			e.emitters[partition] = &nullEmitter{}
+			return e.emitters[partition]
+		}
 		e.fds[partition] = fd
-		w := bufio.NewWriter(fd)
-		e.emitters[partition] = newPrintEmitter(w)
+		var fw io.Writer = fd
+		if e.temp && optMaxTempBytes > 0 {
+			fw = budgetWriter{fd, e.run}
+		}
+		e.sizes[partition] = &countingWriter{w: fw}
+		w := bufio.NewWriter(e.sizes[partition])
+		e.bufs[partition] = w
+		if e.temp && optIntermediate == "framed" {
+			e.emitters[partition] = newFramedEmitter(w)
+		} else {
+			e.emitters[partition] = newPrintEmitter(w)
+		}
+		if !e.temp {
+			return e.emitters[partition]
+		}
+		writeProtoHeader(e.emitters[partition])
+		if optChecksum != "" {
+			e.emitters[partition] = &checksumEmitter{e.emitters[partition], checksums[optChecksum]()}
+		}
+	}
+
+	return e.emitters[partition]
+}
+
+// maybeRotate closes the partition's file once it reaches -rotate-bytes, so
+// the next record for the partition starts a new part
+func (e *partitionEmitter) maybeRotate(partition uint32) {
+
+	if !e.temp || optRotateBytes <= 0 || e.fds[partition] == nil {
+		return
+	}
+
+	if e.sizes[partition].n+int64(e.bufs[partition].Buffered()) < optRotateBytes {
+		return
 	}
 
-	e.emitters[partition].Emit(key, value)
+	e.emitters[partition].Flush()
+	e.fds[partition].Close()
+	e.emitters[partition] = nil
+	e.fds[partition] = nil
+}
+
+// files returns all the files written for the partition, in order
+func (e *partitionEmitter) files(partition int) []string {
+	return e.parts[partition]
 }
 
 func (e *partitionEmitter) Flush() {
@@ -89,12 +441,49 @@ func (e *partitionEmitter) Flush() {
 			w.Flush()
 		}
 	}
+	e.unflushed = 0
+	e.lastFlush = time.Now()
 }
 
-func (e *partitionEmitter) Close() {
-	for _, w := range e.fds {
-		if w != nil {
-			w.Close()
+// RangeShardEmitter writes key/value pairs to a file for each key range, as
+// chosen by a RangePartitioner.  Concatenating the files in order, each
+// sorted, gives totally ordered output.  The files are named after the
+// template, with the shard number appended (template.0000, template.0001, ...),
+// and are only created when something is written to them.  Call Close when
+// done, and check its error.
+type RangeShardEmitter struct {
+	*partitionEmitter
+}
+
+// NewRangeShardEmitter returns a RangeShardEmitter with a shard for each range
+// between the sorted boundaries, and one before the first and after the last.
+func NewRangeShardEmitter(template string, boundaries []string) *RangeShardEmitter {
+	pe := newPartitionEmitter(nil, uint(len(boundaries)+1), template)
+	pe.p = &RangePartitioner{boundaries}
+	pe.temp = false
+	return &RangeShardEmitter{pe}
+}
+
+// FileName returns the name of the file for a shard, or "" if nothing has been written to it
+func (e *RangeShardEmitter) FileName(shard int) string {
+	return e.FileNames[shard]
+}
+
+// Close flushes and closes the files.  It returns the first error creating,
+// writing or closing them; pairs for a partition whose file couldn't be created
+// are dropped.
+func (e *partitionEmitter) Close() error {
+	e.Flush()
+	for partition, fd := range e.fds {
+		if fd == nil {
+			continue
+		}
+		if err := e.bufs[partition].Flush(); err != nil && e.err == nil {
+			e.err = err
+		}
+		if err := fd.Close(); err != nil && e.err == nil {
+			e.err = err
 		}
 	}
+	return e.err
 }
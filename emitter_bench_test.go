@@ -0,0 +1,46 @@
+package dmrgo
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// the pairs a fan-out-heavy mapper emits for one record
+func benchPairs(n int) []KeyValue {
+	pairs := make([]KeyValue, n)
+	for i := range pairs {
+		pairs[i] = KeyValue{fmt.Sprintf("key%d", i), "1"}
+	}
+	return pairs
+}
+
+func BenchmarkPartitionEmit(b *testing.B) {
+
+	pairs := benchPairs(16)
+
+	pe := newPartitionEmitter(nil, 4, filepath.Join(b.TempDir(), "emit"))
+	defer pe.Close()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for _, kv := range pairs {
+			pe.Emit(kv.Key, kv.Value)
+		}
+	}
+}
+
+func BenchmarkPartitionEmitBatch(b *testing.B) {
+
+	pairs := benchPairs(16)
+
+	pe := newPartitionEmitter(nil, 4, filepath.Join(b.TempDir(), "batch"))
+	defer pe.Close()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		pe.EmitBatch(pairs)
+	}
+}
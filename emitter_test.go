@@ -0,0 +1,262 @@
+package dmrgo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// partitionContents returns the contents of the files written by the partition emitter, by partition
+func partitionContents(t *testing.T, pe *partitionEmitter) []string {
+	t.Helper()
+
+	contents := make([]string, pe.partitions)
+	for partition := range contents {
+		for _, fname := range pe.files(partition) {
+			b, err := ioutil.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents[partition] += string(b)
+		}
+	}
+	return contents
+}
+
+func TestPartitionEmitterEmitAll(t *testing.T) {
+
+	pe := newPartitionEmitter(nil, 3, filepath.Join(t.TempDir(), "map-out"))
+
+	pe.Emit("a", "1")
+	pe.EmitAll("dim", "x")
+	pe.Close()
+
+	contents := partitionContents(t, pe)
+
+	aPartition := partitionOf("a", 3)
+	for partition, s := range contents {
+		want := "dim\tx\n"
+		if uint32(partition) == aPartition {
+			want = "a\t1\n" + want
+		}
+		if s != want {
+			t.Errorf("partition %d has %q, want %q", partition, s, want)
+		}
+	}
+}
+
+func TestPartitionEmitterFlushInterval(t *testing.T) {
+
+	onDisk := func(pe *partitionEmitter) string {
+		b, err := ioutil.ReadFile(pe.files(0)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// off by default: nothing reaches the file until the buffer fills or it's closed
+	pe := newPartitionEmitter(nil, 1, filepath.Join(t.TempDir(), "default"))
+	pe.Emit("a", "1")
+	time.Sleep(10 * time.Millisecond)
+	pe.Emit("b", "2")
+	if s := onDisk(pe); s != "" {
+		t.Errorf("without -flush-interval, %q was on disk mid-run", s)
+	}
+	pe.Close()
+
+	setFlag(t, "flush-interval", "5ms")
+
+	pe = newPartitionEmitter(nil, 1, filepath.Join(t.TempDir(), "interval"))
+	defer pe.Close()
+
+	pe.Emit("a", "1")
+	time.Sleep(10 * time.Millisecond)
+	pe.Emit("b", "2")
+
+	if s := onDisk(pe); s != "a\t1\nb\t2\n" {
+		t.Errorf("after -flush-interval, %q was on disk, want both records", s)
+	}
+}
+
+func TestPartitionEmitterRotate(t *testing.T) {
+
+	setFlag(t, "rotate-bytes", "16")
+
+	pe := newPartitionEmitter(nil, 2, filepath.Join(t.TempDir(), "map-out"))
+
+	var want string
+	for i := 0; i < 10; i++ {
+		kv := fmt.Sprintf("a\t%d\n", 1000+i)
+		pe.Emit("a", fmt.Sprint(1000+i))
+		want += kv
+	}
+
+	if err := pe.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// "a" is in partition 0, and three of its 7-byte records fill a part
+	parts := pe.files(0)
+	if len(parts) != 4 {
+		t.Errorf("partition 0 was written to %q, want 4 parts", parts)
+	}
+	for i, fname := range parts {
+		if want := fmt.Sprintf(".0000.part%d", i); !strings.HasSuffix(fname, want) {
+			t.Errorf("part %d is %q, want a name ending %q", i, fname, want)
+		}
+	}
+
+	if got := partitionContents(t, pe)[0]; got != want {
+		t.Errorf("the parts concatenate to %q, want %q", got, want)
+	}
+
+	if len(pe.files(1)) != 0 {
+		t.Errorf("the empty partition 1 was written to %q", pe.files(1))
+	}
+
+	// the reducer sorts all the parts of its partition together
+	_, lines, err := runLocal(t, wordJob{}, []string{strings.Repeat("a b c d\n", 50)}, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a\t50", "b\t50", "c\t50", "d\t50"}; !reflect.DeepEqual(sortedLines(lines), want) {
+		t.Errorf("reduced %q, want %q", sortedLines(lines), want)
+	}
+}
+
+func TestPartitionEmitterCreateFailure(t *testing.T) {
+
+	quietStderr(t)
+	setFlag(t, "create-retries", "2")
+	setFlag(t, "create-backoff", "1ms")
+	resetCounters()
+
+	// the directory is missing, so every attempt fails
+	run := newRunState()
+	pe := newPartitionEmitter(run, 2, filepath.Join(t.TempDir(), "no-such-dir", "map-out"))
+
+	pe.Emit("a", "1")
+	pe.Emit("a", "2")
+	pe.EmitAll("b", "1")
+
+	if err := pe.Close(); err == nil {
+		t.Errorf("Close didn't report the files which couldn't be created")
+	}
+
+	// the failure is recorded on the run, and the partitions have no files
+	if errs := run.errors(); len(errs) != 2 {
+		t.Errorf("the run recorded %v, want an error for each partition", errs)
+	}
+	if run.stopped() {
+		t.Errorf("without -fail-fast, the failure stopped the run")
+	}
+	for partition := 0; partition < 2; partition++ {
+		if files := pe.files(partition); len(files) != 0 || pe.FileNames[partition] != "" {
+			t.Errorf("partition %d lists files %q", partition, files)
+		}
+	}
+
+	if got := counterTotals()["dmrgo"]["create retries"]; got != 4 {
+		t.Errorf("create retries=%d, want 2 for each partition", got)
+	}
+}
+
+func TestMultiEmitter(t *testing.T) {
+
+	var s SliceEmitter
+	m := NewMultiEmitter(&s, &nullEmitter{})
+
+	m.Emit("a", "1")
+	EmitBatch(m, []KeyValue{{"b", "2"}, {"c", "3"}})
+	m.EmitAll("d", "4")
+	m.Flush()
+
+	want := []KeyValue{{"a", "1"}, {"b", "2"}, {"c", "3"}, {"d", "4"}}
+	if !reflect.DeepEqual(s.KeyValues, want) {
+		t.Errorf("the SliceEmitter captured %v, want %v", s.KeyValues, want)
+	}
+}
+
+func TestContextEmitterCancel(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var s SliceEmitter
+	e := WithContext(ctx, &s)
+
+	var emitted int
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			cancel()
+		}
+		e.Emit(fmt.Sprint(i), "v")
+		if e.Err() != nil {
+			break
+		}
+		emitted++
+	}
+
+	if emitted != 5 || len(s.KeyValues) != 5 {
+		t.Errorf("emitted %d, and %d got through, want 5 before the cancel", emitted, len(s.KeyValues))
+	}
+
+	if e.Err() != context.Canceled {
+		t.Errorf("Err()=%v after the cancel, want context.Canceled", e.Err())
+	}
+
+	e.EmitAll("x", "v")
+	if len(s.KeyValues) != 5 {
+		t.Errorf("EmitAll after the cancel got through: %v", s.KeyValues)
+	}
+}
+
+func TestRangeShardEmitter(t *testing.T) {
+
+	quietStderr(t)
+
+	// the -intermediate, -checksum and -proto-header formats are only for intermediate files
+	setFlag(t, "intermediate", "framed")
+	setFlag(t, "checksum", "adler32")
+	setFlag(t, "proto-header", "true")
+
+	template := filepath.Join(t.TempDir(), "shard")
+	e := NewRangeShardEmitter(template, []string{"g", "p"})
+
+	for _, k := range []string{"zebra", "apple", "g", "hat", "p", "", "f"} {
+		e.Emit(k, "1")
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"apple\t1\n\t1\nf\t1\n", "g\t1\nhat\t1\n", "zebra\t1\np\t1\n"}
+	for shard, w := range want {
+		if e.FileName(shard) == "" {
+			t.Errorf("shard %d wasn't written", shard)
+			continue
+		}
+		b, err := ioutil.ReadFile(e.FileName(shard))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != w {
+			t.Errorf("shard %d has %q, want %q", shard, b, w)
+		}
+	}
+
+	// a shard which can't be created is reported by Close
+	e = NewRangeShardEmitter(filepath.Join(template, "no-such-dir", "shard"), nil)
+	e.Emit("a", "1")
+	if err := e.Close(); err == nil {
+		t.Errorf("Close didn't report the shard which couldn't be created")
+	}
+}
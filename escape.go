@@ -0,0 +1,79 @@
+package dmrgo
+
+// Escaping keys and values in the line format
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"strings"
+)
+
+// Escaper escapes keys and values so they can be written as lines, and
+// reverses it when they are read back.  Unescape(Escape(s)) must return s.
+type Escaper interface {
+	Escape(s string) string
+	Unescape(s string) string
+}
+
+// NoEscaper writes keys and values as they are.  Keys must then not contain
+// the separator, and neither may contain newlines.
+type NoEscaper struct{}
+
+// Escape implements the Escaper interface
+func (NoEscaper) Escape(s string) string { return s }
+
+// Unescape implements the Escaper interface
+func (NoEscaper) Unescape(s string) string { return s }
+
+// BackslashEscaper escapes tabs, newlines, carriage returns and backslashes
+// as \t, \n, \r and \\.  Other bytes, including those of a -kv-separator
+// other than a tab, are left alone.
+type BackslashEscaper struct{}
+
+var backslashEscapes = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+var backslashUnescapes = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r")
+
+// Escape implements the Escaper interface
+func (BackslashEscaper) Escape(s string) string { return backslashEscapes.Replace(s) }
+
+// Unescape implements the Escaper interface
+func (BackslashEscaper) Unescape(s string) string { return backslashUnescapes.Replace(s) }
+
+// PercentEscaper escapes tabs, newlines, carriage returns and percent signs
+// as URLs do: %09, %0A, %0D and %25.  Other bytes are left alone.
+type PercentEscaper struct{}
+
+var percentEscapes = strings.NewReplacer("%", "%25", "\t", "%09", "\n", "%0A", "\r", "%0D")
+var percentUnescapes = strings.NewReplacer("%25", "%", "%09", "\t", "%0A", "\n", "%0D", "\r", "%0a", "\n", "%0d", "\r")
+
+// Escape implements the Escaper interface
+func (PercentEscaper) Escape(s string) string { return percentEscapes.Replace(s) }
+
+// Unescape implements the Escaper interface
+func (PercentEscaper) Unescape(s string) string { return percentUnescapes.Replace(s) }
+
+// the escapers for -escape, by name
+var escapers = map[string]Escaper{
+	"none":      NoEscaper{},
+	"backslash": BackslashEscaper{},
+	"percent":   PercentEscaper{},
+}
+
+// the name of the escaper to use
+var optEscape string
+
+// LineEscaper, if set, escapes the keys and values of the line format written
+// by WriteKeyValue and read by ReadKeyValue, in place of the -escape flag.
+// The map input, read with ReadValue, is never unescaped.
+var LineEscaper Escaper
+
+// lineEscaper returns the escaper for the line format
+func lineEscaper() Escaper {
+	if LineEscaper != nil {
+		return LineEscaper
+	}
+	if e, ok := escapers[optEscape]; ok {
+		return e
+	}
+	return NoEscaper{}
+}
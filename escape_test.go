@@ -0,0 +1,45 @@
+package dmrgo
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEscapers(t *testing.T) {
+
+	values := []string{"", "plain", "tab\there", "two\nlines", "cr\r\n", `back\slash`, `\t literally`, "100%", "%09 literally", "\t\n\\%"}
+
+	for name, e := range escapers {
+		for _, v := range values {
+			if got := e.Unescape(e.Escape(v)); got != v {
+				t.Errorf("%s: %q round tripped to %q", name, v, got)
+			}
+		}
+	}
+
+	// escaped, keys and values survive the line format
+	for _, name := range []string{"backslash", "percent"} {
+		setFlag(t, "escape", name)
+
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		for _, v := range values {
+			WriteKeyValue(w, KeyValue{v, v})
+		}
+		w.Flush()
+
+		if n := strings.Count(buf.String(), "\n"); n != len(values) {
+			t.Errorf("%s: wrote %d lines for %d pairs", name, n, len(values))
+		}
+
+		br := bufio.NewReader(&buf)
+		for _, v := range values {
+			kv, err := ReadKeyValue(br)
+			if err != nil || kv.Key != v || kv.Value != v {
+				t.Errorf("%s: read %v, %v, want %q for key and value", name, kv, err, v)
+			}
+		}
+	}
+}
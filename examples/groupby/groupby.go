@@ -0,0 +1,31 @@
+// Count the lines of stdin by their first field, using GroupBy
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dgryski/dmrgo"
+)
+
+func firstField(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func count(key string, lines []string) []dmrgo.KeyValue {
+	return []dmrgo.KeyValue{{Key: key, Value: strconv.Itoa(len(lines))}}
+}
+
+func main() {
+	for _, kv := range dmrgo.GroupBy(os.Stdin, firstField, count) {
+		fmt.Printf("%s\t%s\n", kv.Key, kv.Value)
+	}
+}
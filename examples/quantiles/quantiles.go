@@ -0,0 +1,52 @@
+// Latency percentiles per key, using the Quantile helper
+// Input lines are "key value", where value is a number.
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgryski/dmrgo"
+)
+
+type MRQuantiles struct{}
+
+func (mr *MRQuantiles) Map(key string, value string, emitter dmrgo.Emitter) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return
+	}
+	emitter.Emit(fields[0], fields[1])
+}
+
+func (mr *MRQuantiles) MapFinal(emitter dmrgo.Emitter) {}
+
+func (mr *MRQuantiles) Reduce(key string, values []string, emitter dmrgo.Emitter) {
+
+	q := dmrgo.NewQuantile(100)
+
+	for _, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+		q.Add(f)
+	}
+
+	if q.Count() == 0 {
+		return
+	}
+
+	emitter.Emit(key, fmt.Sprintf("p50=%g\tp95=%g\tp99=%g", q.Quantile(0.5), q.Quantile(0.95), q.Quantile(0.99)))
+}
+
+func main() {
+
+	flag.Parse()
+
+	dmrgo.Main(new(MRQuantiles))
+}
@@ -0,0 +1,70 @@
+// Find the most frequent words, using the TopN helper
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dgryski/dmrgo"
+)
+
+type wordCount struct {
+	word  string
+	count int
+}
+
+type MRTopWords struct {
+	mu  sync.Mutex
+	top *dmrgo.TopN[wordCount]
+}
+
+func NewTopWords(n int) *MRTopWords {
+	mr := new(MRTopWords)
+	mr.top = dmrgo.NewTopN[wordCount](n)
+	return mr
+}
+
+func (mr *MRTopWords) Map(key string, value string, emitter dmrgo.Emitter) {
+	for _, word := range strings.Fields(strings.ToLower(value)) {
+		emitter.Emit(word, "1")
+	}
+}
+
+func (mr *MRTopWords) MapFinal(emitter dmrgo.Emitter) {}
+
+func (mr *MRTopWords) Reduce(key string, values []string, emitter dmrgo.Emitter) {
+
+	count := 0
+	for _, v := range values {
+		c, _ := strconv.Atoi(v)
+		count += c
+	}
+
+	// reducers for different partitions may run concurrently
+	mr.mu.Lock()
+	mr.top.Add(wordCount{key, count}, float64(count))
+	mr.mu.Unlock()
+}
+
+// ReduceFinal emits the most frequent words.  The results are only global
+// when run with a single partition (the default).
+func (mr *MRTopWords) ReduceFinal(emitter dmrgo.Emitter) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	for _, wc := range mr.top.Results() {
+		emitter.Emit(wc.word, strconv.Itoa(wc.count))
+	}
+}
+
+func main() {
+
+	n := flag.Int("n", 10, "number of words to output")
+
+	flag.Parse()
+
+	dmrgo.Main(NewTopWords(*n))
+}
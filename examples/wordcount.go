@@ -7,11 +7,8 @@ import (
 	"flag"
 	"fmt"
 	"github.com/dgryski/dmrgo"
-	"log"
 	"os"
-	"runtime/pprof"
 	"strconv"
-	"strings"
 	"sync/atomic"
 )
 
@@ -45,6 +42,10 @@ func (p *WordCountProto) MarshalKV(key interface{}, value interface{}) *dmrgo.Ke
 	return &dmrgo.KeyValue{ks, strconv.Itoa(vi)}
 }
 
+func init() {
+	dmrgo.RegisterProtocol("wc", func() dmrgo.StreamProtocol { return new(WordCountProto) })
+}
+
 type MRWordCount struct {
 	protocol dmrgo.StreamProtocol // overkill -- we would normally just inline the un/marshal calls
 
@@ -62,19 +63,7 @@ func NewWordCount(proto dmrgo.StreamProtocol) dmrgo.MapReduceJob {
 
 func (mr *MRWordCount) Map(key string, value string, emitter dmrgo.Emitter) {
 
-	lower := strings.ToLower(string(value))
-
-	letters := strings.Map(func(r rune) rune {
-		if r >= 'a' && r <= 'z' {
-			return r
-		}
-
-		return ' '
-	},
-		lower)
-
-	trimmed := strings.TrimSpace(letters)
-	words := strings.Fields(trimmed)
+	words := dmrgo.Tokenize(value)
 
 	w := uint32(0)
 	for _, word := range words {
@@ -91,6 +80,13 @@ func (mr *MRWordCount) MapFinal(emitter dmrgo.Emitter) {
 	dmrgo.IncrCounter("Program", "mapped words", int(mr.mappedWords))
 }
 
+// MergeValues lets the standalone runner sum counts before the shuffle
+func (mr *MRWordCount) MergeValues(a, b string) string {
+	ai, _ := strconv.Atoi(a)
+	bi, _ := strconv.Atoi(b)
+	return strconv.Itoa(ai + bi)
+}
+
 func (mr *MRWordCount) Reduce(key string, values []string, emitter dmrgo.Emitter) {
 
 	counts := []int{}
@@ -105,33 +101,15 @@ func (mr *MRWordCount) Reduce(key string, values []string, emitter dmrgo.Emitter
 	emitter.Emit(kv.Key, kv.Value)
 }
 
-var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
-
 func main() {
 
 	var use_proto = flag.String("proto", "wc", "use protocol (json/wc/tsv)")
 
 	flag.Parse()
 
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
-	}
-
-	var proto dmrgo.StreamProtocol
-
-	if *use_proto == "json" {
-		proto = new(dmrgo.JSONProtocol)
-	} else if *use_proto == "wc" {
-		proto = new(WordCountProto)
-	} else if *use_proto == "tsv" {
-		proto = new(dmrgo.TSVProtocol)
-	} else {
-		fmt.Println("unknown proto=", *use_proto)
+	proto, err := dmrgo.ProtocolByName(*use_proto)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
@@ -0,0 +1,124 @@
+package dmrgo
+
+// Stopping a standalone run at the first error
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// abort the run on the first map or reduce error, rather than carrying on without the failed part
+var optFailFast bool
+
+// runState tracks the first error of a standalone run.  With -fail-fast, the
+// first error cancels the run's context, and the workers stop taking new work.
+// Some errors, such as running out of -max-temp-bytes, stop the run regardless.
+type runState struct {
+	// bytes written to intermediate files so far, for -max-temp-bytes.  It's
+	// first so it's aligned for the atomic operations on 32-bit platforms.
+	tempBytes int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	err  error
+	errs []error
+}
+
+// the standalone run in progress, if any, for stopping it from code with no
+// runState to hand, such as the -on-bad-record policy
+var currentRunMu sync.Mutex
+var currentRun *runState
+
+// setCurrentRun makes run the run in progress, or clears it if run is nil
+func setCurrentRun(run *runState) {
+	currentRunMu.Lock()
+	currentRun = run
+	currentRunMu.Unlock()
+}
+
+// stopCurrentRun stops the run in progress with err, and reports whether there was one
+func stopCurrentRun(err error) bool {
+	currentRunMu.Lock()
+	run := currentRun
+	currentRunMu.Unlock()
+
+	if run == nil {
+		return false
+	}
+
+	run.stop(err)
+	return true
+}
+
+func newRunState() *runState {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &runState{ctx: ctx, cancel: cancel}
+}
+
+// fail records an error, which has already been reported
+func (s *runState) fail(err error) {
+	s.record(err)
+
+	if optFailFast {
+		s.cancel()
+	}
+}
+
+// stop records an error the run can't carry on after, with or without -fail-fast
+func (s *runState) stop(err error) {
+	s.record(err)
+	s.cancel()
+}
+
+func (s *runState) record(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.errs = append(s.errs, err)
+	s.mu.Unlock()
+}
+
+// stopped reports whether the workers should stop taking new work
+func (s *runState) stopped() bool {
+	return s.ctx.Err() != nil
+}
+
+// failedFast returns the first error, if there was one and the run was stopped by it
+func (s *runState) failedFast() error {
+	if !s.stopped() {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// errors returns all the errors recorded, in the order they happened
+func (s *runState) errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+// failedFastError is the error of a run stopped by -fail-fast
+type failedFastError struct {
+	err error
+}
+
+func (e *failedFastError) Error() string {
+	return "failing fast: " + e.err.Error()
+}
+
+// abortRun removes the files of a failed run, and returns its first error
+func abortRun(err error, files []string) error {
+	for _, f := range files {
+		os.Remove(f)
+	}
+	return &failedFastError{err}
+}
@@ -0,0 +1,106 @@
+package dmrgo
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// nanJob marshals a NaN for the word "nan", which the default -nonfinite policy rejects
+type nanJob struct {
+	wordJob
+}
+
+func (nanJob) Map(key string, value string, emitter Emitter) {
+	for _, w := range strings.Fields(value) {
+		if w == "nan" {
+			kv := new(JSONProtocol).MarshalKV(w, math.NaN())
+			emitter.Emit(kv.Key, kv.Value)
+			continue
+		}
+		emitter.Emit(w, "1")
+	}
+}
+
+// leftovers returns the files a standalone run shouldn't leave behind after failing
+func leftovers(t *testing.T) []string {
+	t.Helper()
+
+	var files []string
+	for _, pattern := range []string{"tmp-*", "red-out*", successMarker} {
+		m, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, m...)
+	}
+	return files
+}
+
+func TestFailFast(t *testing.T) {
+
+	quietStderr(t)
+	inTempDir(t)
+	keepRunFlags(t)
+	setFlag(t, "fail-fast", "true")
+
+	for _, fname := range []string{"input0", "input1"} {
+		if err := ioutil.WriteFile(fname, []byte("a b\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the inputs are mapped one at a time, so the first missing one is the first error
+	_, err := RunLocalFiles(wordJob{}, []string{"input0", "missing0", "input1", "missing1"}, &RunOptions{Partitions: 2, Mappers: 1})
+	if err == nil || !strings.Contains(err.Error(), "missing0") {
+		t.Fatalf("got error %v, want the first missing input's", err)
+	}
+
+	// the intermediate files of the inputs mapped before it are removed
+	if files := leftovers(t); len(files) != 0 {
+		t.Errorf("the failed run left %q behind", files)
+	}
+}
+
+func TestFailOnBadRecord(t *testing.T) {
+
+	setFlag(t, "on-bad-record", "fail")
+
+	_, _, err := runLocal(t, nanJob{}, []string{"a b\n", "b nan\n"}, &RunOptions{Partitions: 2})
+	if err == nil || !strings.Contains(err.Error(), "bad record") {
+		t.Fatalf("got error %v, want the bad record's", err)
+	}
+
+	if files := leftovers(t); len(files) != 0 {
+		t.Errorf("the failed run left %q behind", files)
+	}
+
+	// a run in memory stops too
+	err = RunReaders(nanJob{}, []io.Reader{strings.NewReader("a nan\n")}, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "bad record") {
+		t.Errorf("RunReaders gave error %v, want the bad record's", err)
+	}
+
+	// and so does a streaming mapper, which exits non-zero once it has flushed its output
+	input := filepath.Join(t.TempDir(), "input")
+	if err := ioutil.WriteFile(input, []byte("a nan b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := captureStdout(t)
+
+	setFlag(t, "mapper", "true")
+	flagSet.Parse([]string{input})
+	defer flagSet.Parse(nil)
+
+	if status := runMain(nanJob{}); status != 1 {
+		t.Errorf("the streaming mapper's exit status was %d, want 1", status)
+	}
+
+	if got := stdout(); !strings.HasPrefix(got, "a\t1\n") {
+		t.Errorf("the streaming mapper wrote %q, want its output flushed", got)
+	}
+}
@@ -0,0 +1,97 @@
+package dmrgo
+
+// Reading fixed-width records
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// FixedWidthReader reads lines made up of fixed-width fields, as found in
+// mainframe-style exports.  Fields are trimmed of surrounding spaces.  A line
+// shorter than the total width is handled by the -on-bad-record policy, and the
+// missing fields are read as empty.  Widths are in bytes.
+type FixedWidthReader struct {
+	Widths []int
+
+	br *bufio.Reader
+}
+
+// NewFixedWidthReader returns a reader for records with the given field widths
+func NewFixedWidthReader(r io.Reader, widths ...int) *FixedWidthReader {
+	return &FixedWidthReader{Widths: widths, br: bufio.NewReader(r)}
+}
+
+// ReadFields returns the trimmed fields of the next record
+func (r *FixedWidthReader) ReadFields() ([]string, error) {
+
+	s, err := readLine(r.br)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, w := range r.Widths {
+		total += w
+	}
+
+	if len(s) < total {
+		badRecord("short records", "fixed-width record is %d bytes, want %d: %q", len(s), total, s)
+	}
+
+	fields := make([]string, len(r.Widths))
+
+	for i, w := range r.Widths {
+		if w > len(s) {
+			w = len(s)
+		}
+		fields[i] = strings.TrimSpace(s[:w])
+		s = s[w:]
+	}
+
+	return fields, nil
+}
+
+// ReadRecord implements the RecordReader interface.  The first field is the
+// key, and the remaining fields are joined with tabs to make the value.
+func (r *FixedWidthReader) ReadRecord() (*KeyValue, error) {
+
+	fields, err := r.ReadFields()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return &KeyValue{}, nil
+	}
+
+	return &KeyValue{fields[0], strings.Join(fields[1:], "\t")}, nil
+}
+
+// ReadStruct reads the next record into the fields of the struct pointed to by v, in order
+func (r *FixedWidthReader) ReadStruct(v interface{}) error {
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("dmrgo: ReadStruct needs a pointer to a struct")
+	}
+	rv = rv.Elem()
+
+	fields, err := r.ReadFields()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < rv.NumField() && i < len(fields); i++ {
+		if err := scanField(fields[i], rv.Field(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package dmrgo
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFixedWidthReader(t *testing.T) {
+
+	quietStderr(t)
+
+	setFlag(t, "on-bad-record", "count")
+	resetCounters()
+
+	// a 6-byte name and a 4-byte count, then a short record
+	input := "alice   12\nbob      7\ncarol\n"
+
+	type row struct {
+		Name  string
+		Count int
+	}
+
+	r := NewFixedWidthReader(strings.NewReader(input), 6, 4)
+
+	want := []row{{"alice", 12}, {"bob", 7}, {"carol", 0}}
+	for i, w := range want {
+		var got row
+		if err := r.ReadStruct(&got); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if got != w {
+			t.Errorf("record %d: got %+v, want %+v", i, got, w)
+		}
+	}
+
+	if err := r.ReadStruct(&row{}); err != io.EOF {
+		t.Errorf("after the last record got %v, want io.EOF", err)
+	}
+
+	if n := counterTotals()["dmrgo"]["short records"]; n != 1 {
+		t.Errorf("short records=%d, want 1", n)
+	}
+
+	kv, err := NewFixedWidthReader(strings.NewReader("k1  v1  v2\n"), 4, 4, 2).ReadRecord()
+	if err != nil || kv.Key != "k1" || kv.Value != "v1\tv2" {
+		t.Errorf("ReadRecord gave %v, %v, want key k1 and value v1<tab>v2", kv, err)
+	}
+}
@@ -0,0 +1,80 @@
+package dmrgo
+
+// Command line flags
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"flag"
+	"time"
+)
+
+// the flag set our flags were last registered on
+var flagSet *flag.FlagSet
+
+// RegisterFlags defines dmrgo's command line flags on fs.  Unless built with the
+// dmrgo_noflags tag, they are defined on the default flag set when the package
+// is initialized, so jobs which call flag.Parse before Main need do nothing else.
+// Programs embedding dmrgo which want to keep the default flag set for
+// themselves can build with dmrgo_noflags and register the flags on a flag set
+// of their own.  If no flag set has been registered, Main registers the flags
+// on the default flag set and parses the command line itself.
+func RegisterFlags(fs *flag.FlagSet) {
+
+	flagSet = fs
+
+	fs.BoolVar(&optDoMap, "mapper", false, "run mapper code on stdin, or the named files")
+	fs.BoolVar(&optDoReduce, "reducer", false, "run reducer on stdin, or the named files")
+	fs.IntVar(&optNumPartitions, "partitions", 1, "parition data into sets")
+	fs.BoolVar(&optDoMapReduce, "mapreduce", false, "run full map/reduce")
+	fs.IntVar(&optNumMappers, "mappers", 0, "number of map processes (default: 2*CPUs, at most one per input)")
+	fs.IntVar(&optMapperQueue, "mapper-queue", 0, "number of inputs queued ahead of the mappers")
+	fs.IntVar(&optNumReducers, "reducers", 4, "number of reducer processes (0 for a map-only job)")
+	fs.StringVar(&optSortBin, "sort-bin", "sort", "sort binary to use")
+	fs.StringVar(&optSortCmd, "sort-cmd", "", "sort command, with {inputs} and {output} placeholders (e.g. \"sort --parallel=8 -S 2G -o {output} {inputs}\")")
+	fs.IntVar(&optSortConcurrency, "sort-concurrency", 0, "number of concurrent sort processes (default: -reducers)")
+	fs.DurationVar(&optSortTimeout, "sort-timeout", 0, "kill a sort which takes longer than this, failing its partition (0 for no limit)")
+	fs.BoolVar(&optVerifyPartitions, "verify-partitions", false, "verify reduced keys belong to their partition")
+	fs.IntVar(&optCombineThreshold, "combine-threshold", 0, "flush the in-mapper combiner after this many entries")
+	fs.BoolVar(&optKeepTemp, "keep-temp", false, "don't remove intermediate files")
+	fs.IntVar(&optLimit, "limit", 0, "only map this many records from each input")
+	fs.BoolVar(&optFailFast, "fail-fast", false, "with -mapreduce, stop and remove all output at the first error")
+	fs.BoolVar(&optCountEmptyLines, "count-empty", false, "count empty input lines")
+	fs.BoolVar(&optAppend, "append", false, "append to the reducer output of previous runs, merging values if the job is a ValueMerger")
+	fs.BoolVar(&optStdout, "stdout", false, "with -mapreduce, concatenate the output partitions to stdout")
+	fs.IntVar(&optReduceGroupLimit, "reduce-group-limit", 0, "stop reducing each partition after this many of its keys have produced output")
+	fs.BoolVar(&optCheckSorted, "check-sorted", false, "verify reducer input is sorted by key")
+	fs.StringVar(&optOnBadRecord, "on-bad-record", "log", "how to handle bad records (log/count/fail)")
+	fs.StringVar(&optChecksum, "checksum", "", "checksum intermediate records (adler32/crc32c)")
+	fs.StringVar(&optCompress, "compress", "", "compress reducer output with the codec for this extension (e.g. .gz)")
+	fs.IntVar(&optMergeKeys, "merge-keys", 10000, "number of keys to hold when merging map output values")
+	fs.BoolVar(&optCombineOnMerge, "combine-on-merge", false, "merge values for the same key as the reducer reads its sorted input")
+	fs.IntVar(&optFlushRecords, "flush-records", 0, "flush intermediate files every this many records")
+	fs.DurationVar(&optFlushInterval, "flush-interval", 0, "flush intermediate files this often")
+	fs.StringVar(&optIntermediate, "intermediate", "lines", "intermediate file format (lines/framed)")
+	fs.Int64Var(&optRotateBytes, "rotate-bytes", 0, "start a new intermediate file part once a partition's file reaches this many bytes (0 to disable)")
+	fs.Int64Var(&optMaxTempBytes, "max-temp-bytes", 0, "with -mapreduce, give up if intermediate files grow past this many bytes (0 for no limit)")
+	fs.StringVar(&optManifest, "manifest", "", "write a JSON manifest of the output files to this path")
+	fs.BoolVar(&optSuccessMarker, "success-marker", true, "with -mapreduce, write a _SUCCESS file once all the output is committed")
+	fs.StringVar(&optRejectOutput, "reject-output", "", "write records rejected by the job to this file")
+	fs.StringVar(&optRejectFormat, "reject-format", "json", "format of the reject output (json/tsv)")
+	fs.Int64Var(&optSplitSize, "split-size", 64<<20, "split a single input file larger than this between the mappers (0 to disable; -limit disables it too)")
+	fs.BoolVar(&optTiming, "timing", false, "report time spent in each phase as counters")
+	fs.DurationVar(&optSlowReduce, "slow-reduce", 0, "report keys whose Reduce takes longer than this")
+	fs.BoolVar(&optGroupSizeCounters, "group-size-counters", false, "count reduce groups by their number of values (1, 2-10, 11-100, 101-1000, 1001+)")
+	fs.StringVar(&optCPUProfile, "cpuprofile", "", "write a cpu profile to this file")
+	fs.StringVar(&optMemProfile, "memprofile", "", "write a memory profile to this file when the job finishes")
+	fs.IntVar(&optCreateRetries, "create-retries", 2, "retry creating an intermediate file this many times")
+	fs.DurationVar(&optCreateBackoff, "create-backoff", 10*time.Millisecond, "wait this long before first retrying to create a file, doubling each time")
+	fs.StringVar(&optKVSeparator, "kv-separator", "\t", "separator between keys and values in intermediate and output lines (keys which prefix others need -intermediate framed, unless it sorts before every key byte)")
+	fs.StringVar(&optKeyFieldSeparator, "key-field-separator", "\x1f", "separator between the fields of composite keys, for jobs with ReduceFields (by default, the ASCII unit separator)")
+	fs.StringVar(&optEscape, "escape", "none", "how to escape separators and newlines in keys and values (none/backslash/percent)")
+	fs.BoolVar(&optTee, "tee", false, "also write emitted key/value pairs to stderr")
+	fs.StringVar(&optInputProto, "input-proto", "", "protocol for the map input")
+	fs.StringVar(&optIntermediateProto, "intermediate-proto", "", "protocol for the map output and reduce input")
+	fs.BoolVar(&optProtoHeader, "proto-header", false, "with -mapreduce, write the intermediate protocol at the start of the map output, and check it when reducing")
+	fs.StringVar(&optOutputProto, "output-proto", "", "protocol for the reduce output")
+	fs.StringVar(&optNonFinite, "nonfinite", "error", "how protocols write NaN and infinite floats (error/null/sentinel)")
+	fs.Float64Var(&optNonFiniteSentinel, "nonfinite-sentinel", 0, "the value written for NaN and infinite floats with -nonfinite sentinel")
+	fs.Var(confFlag{}, "D", "set a job configuration property (key=value); may be repeated")
+}
@@ -0,0 +1,15 @@
+//go:build !dmrgo_noflags
+
+package dmrgo
+
+// Registering our flags on the default flag set
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"flag"
+)
+
+func init() {
+	RegisterFlags(flag.CommandLine)
+}
@@ -0,0 +1,136 @@
+package dmrgo
+
+// Length-prefixed intermediate record format
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+)
+
+// format of the intermediate files: lines or framed
+var optIntermediate string
+
+// framedEmitter writes key/value pairs as a varint length followed by the bytes, for both key and value.
+// Keys and values may contain any bytes, including tabs and newlines.
+type framedEmitter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func newFramedEmitter(w *bufio.Writer) *framedEmitter {
+	e := new(framedEmitter)
+	e.w = w
+	return e
+}
+
+func (e *framedEmitter) Emit(key string, value string) {
+	e.writeString(key)
+	e.writeString(value)
+}
+
+func (e *framedEmitter) writeString(s string) {
+	n := binary.PutUvarint(e.buf[:], uint64(len(s)))
+	e.w.Write(e.buf[:n])
+	e.w.WriteString(s)
+}
+
+func (e *framedEmitter) Flush() {
+	e.w.Flush()
+}
+
+// framedRecordReader reads records written by framedEmitter
+type framedRecordReader struct {
+	br *bufio.Reader
+}
+
+func (r *framedRecordReader) ReadRecord() (*KeyValue, error) {
+
+	k, err := r.readString()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := r.readString()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return &KeyValue{k, v}, nil
+}
+
+func (r *framedRecordReader) readString() (string, error) {
+
+	n, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.br, b); err != nil {
+		return "", unexpectedEOF(err)
+	}
+
+	return string(b), nil
+}
+
+// sliceRecordReader returns records from a slice
+type sliceRecordReader struct {
+	kvs []KeyValue
+}
+
+func (r *sliceRecordReader) ReadRecord() (*KeyValue, error) {
+	if len(r.kvs) == 0 {
+		return nil, io.EOF
+	}
+	kv := &r.kvs[0]
+	r.kvs = r.kvs[1:]
+	return kv, nil
+}
+
+// sortFramedFiles reads all the records from the framed files and sorts them by key,
+// and then by value if byValue is set, in memory
+func sortFramedFiles(fns []string, byValue bool) ([]KeyValue, error) {
+
+	var kvs []KeyValue
+
+	for _, fn := range fns {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &framedRecordReader{bufio.NewReader(f)}
+		for {
+			kv, err := r.ReadRecord()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			kvs = append(kvs, *kv)
+		}
+
+		f.Close()
+	}
+
+	sortKeyValues(kvs, byValue)
+
+	return kvs, nil
+}
+
+// sortKeyValues sorts the pairs by key, and then by value if byValue is set
+func sortKeyValues(kvs []KeyValue, byValue bool) {
+	sort.SliceStable(kvs, func(i, j int) bool {
+		if byValue && kvs[i].Key == kvs[j].Key {
+			return kvs[i].Value < kvs[j].Value
+		}
+		return kvs[i].Key < kvs[j].Key
+	})
+}
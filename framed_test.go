@@ -0,0 +1,40 @@
+package dmrgo
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// framedJob emits keys and values with tabs and newlines in them, which the
+// line format can't carry, and reduces them to quoted keys and joined values
+type framedJob struct {
+	wordJob
+}
+
+func (framedJob) Map(key string, value string, emitter Emitter) {
+	for _, w := range strings.Fields(value) {
+		emitter.Emit(strings.Replace(w, "_", "\t", -1), w+"\n")
+	}
+}
+
+func (framedJob) Reduce(key string, values []string, emitter Emitter) {
+	emitter.Emit(strconv.Quote(key), strconv.Quote(strings.Join(values, "")))
+}
+
+func TestFramedIntermediate(t *testing.T) {
+
+	setFlag(t, "intermediate", "framed")
+
+	// "a" and "a_b" share a prefix, which the line format would sort between "a"'s values
+	_, lines, err := runLocal(t, framedJob{}, []string{"a a_b a\n", "a_b c\n"}, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`"a"` + "\t" + `"a\na\n"`, `"a\tb"` + "\t" + `"a_b\na_b\n"`, `"c"` + "\t" + `"c\n"`}
+	if got := sortedLines(lines); !reflect.DeepEqual(got, want) {
+		t.Errorf("reduced %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package dmrgo
+
+// Grouping and reducing small inputs in memory
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"io"
+	"sort"
+)
+
+// GroupBy reads the lines of input, groups them by keyFn, and calls reduceFn
+// with each key and its lines, in key order.  It returns everything reduceFn
+// returned.  This is the whole map/sort/reduce in memory, for inputs which fit,
+// without writing a MapReduceJob.  Unlike the reducer, it isn't affected by the
+// command line flags.
+func GroupBy(input io.Reader, keyFn func(line string) string, reduceFn func(key string, lines []string) []KeyValue) []KeyValue {
+
+	br := bufio.NewReader(input)
+
+	var kvs []KeyValue
+
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			readError(err)
+			break
+		}
+		kvs = append(kvs, KeyValue{keyFn(line), line})
+	}
+
+	// stable, so each key's lines stay in input order
+	sort.SliceStable(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+
+	// group the sorted lines ourselves, rather than with reduceRecords, which
+	// applies the reducer flags (-reduce-group-limit, -check-sorted and so on)
+	var out []KeyValue
+
+	for i := 0; i < len(kvs); {
+		j := i + 1
+		for j < len(kvs) && kvs[j].Key == kvs[i].Key {
+			j++
+		}
+
+		lines := make([]string, j-i)
+		for n, kv := range kvs[i:j] {
+			lines[n] = kv.Value
+		}
+
+		out = append(out, reduceFn(kvs[i].Key, lines)...)
+		i = j
+	}
+
+	return out
+}
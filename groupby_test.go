@@ -0,0 +1,43 @@
+package dmrgo
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGroupBy(t *testing.T) {
+
+	firstField := func(line string) string {
+		if f := strings.Fields(line); len(f) > 0 {
+			return f[0]
+		}
+		return ""
+	}
+
+	joined := func(key string, lines []string) []KeyValue {
+		return []KeyValue{{key, strconv.Itoa(len(lines)) + ":" + strings.Join(lines, "|")}}
+	}
+
+	input := "b 1\na 1\n\nb 2\na 2\nc\n"
+
+	want := []KeyValue{{"", "1:"}, {"a", "2:a 1|a 2"}, {"b", "2:b 1|b 2"}, {"c", "1:c"}}
+
+	if got := GroupBy(strings.NewReader(input), firstField, joined); !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupBy gave %v, want %v", got, want)
+	}
+
+	// the reducer's flags don't apply
+	setFlag(t, "reduce-group-limit", "1")
+	setFlag(t, "group-size-counters", "true")
+	resetCounters()
+
+	if got := GroupBy(strings.NewReader(input), firstField, joined); !reflect.DeepEqual(got, want) {
+		t.Errorf("with reducer flags set, GroupBy gave %v, want %v", got, want)
+	}
+
+	if got := counterTotals()["dmrgo group sizes"]; len(got) != 0 {
+		t.Errorf("GroupBy counted group sizes %v", got)
+	}
+}
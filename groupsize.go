@@ -0,0 +1,65 @@
+package dmrgo
+
+// Counters for the distribution of reduce group sizes
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+// count reduce groups by their number of values, to look for skew
+var optGroupSizeCounters bool
+
+// the upper limits of the group size buckets, and their counter names.  The
+// last bucket takes everything larger.
+var groupSizeBuckets = []struct {
+	max  int
+	name string
+}{
+	{1, "1"},
+	{10, "2-10"},
+	{100, "11-100"},
+	{1000, "101-1000"},
+	{0, "1001+"},
+}
+
+// groupSizes counts the reduce groups in each size bucket
+type groupSizes struct {
+	counts []int
+}
+
+// newGroupSizes returns a groupSizes, or nil if -group-size-counters isn't set.  The methods do nothing on nil.
+func newGroupSizes() *groupSizes {
+	if !optGroupSizeCounters {
+		return nil
+	}
+	return &groupSizes{make([]int, len(groupSizeBuckets))}
+}
+
+// add counts a group with n values
+func (g *groupSizes) add(n int) {
+
+	if g == nil {
+		return
+	}
+
+	last := len(groupSizeBuckets) - 1
+	for i, b := range groupSizeBuckets[:last] {
+		if n <= b.max {
+			g.counts[i]++
+			return
+		}
+	}
+	g.counts[last]++
+}
+
+// report emits a counter for each non-empty bucket
+func (g *groupSizes) report() {
+
+	if g == nil {
+		return
+	}
+
+	for i, b := range groupSizeBuckets {
+		if g.counts[i] > 0 {
+			IncrCounter("dmrgo group sizes", b.name, g.counts[i])
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package dmrgo
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestGroupSizeCounters(t *testing.T) {
+
+	quietStderr(t)
+
+	// one group at each boundary
+	sizes := []int{1, 2, 10, 11, 100, 101, 1000, 1001}
+
+	var kvs []KeyValue
+	for _, n := range sizes {
+		key := strconv.Itoa(100000 + n)
+		for i := 0; i < n; i++ {
+			kvs = append(kvs, KeyValue{key, "1"})
+		}
+	}
+
+	resetCounters()
+
+	var e SliceEmitter
+	reduceRecords(wordJob{}, &sliceRecordReader{kvs}, &e)
+
+	if got := counterTotals()["dmrgo group sizes"]; len(got) != 0 {
+		t.Errorf("without -group-size-counters, counted %v", got)
+	}
+
+	setFlag(t, "group-size-counters", "true")
+	resetCounters()
+
+	reduceRecords(wordJob{}, &sliceRecordReader{kvs}, &e)
+
+	want := map[string]int64{"1": 1, "2-10": 2, "11-100": 2, "101-1000": 2, "1001+": 1}
+	if got := counterTotals()["dmrgo group sizes"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("group sizes %v, want %v", got, want)
+	}
+}
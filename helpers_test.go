@@ -0,0 +1,163 @@
+package dmrgo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// quietStderr discards what's written to stderr, such as the counters, until the test ends
+func quietStderr(tb testing.TB) {
+	tb.Helper()
+
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	stderr := os.Stderr
+	os.Stderr = null
+	tb.Cleanup(func() {
+		os.Stderr = stderr
+		null.Close()
+	})
+}
+
+// setFlag sets the named flag until the test ends
+func setFlag(tb testing.TB, name, value string) {
+	tb.Helper()
+
+	f := flagSet.Lookup(name)
+	if f == nil {
+		tb.Fatalf("no flag -%s", name)
+	}
+
+	old := f.Value.String()
+	if err := flagSet.Set(name, value); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { flagSet.Set(name, old) })
+}
+
+// wordJob emits a count of 1 for each word of its input, and sums them
+type wordJob struct{}
+
+func (wordJob) Map(key string, value string, emitter Emitter) {
+	for _, w := range strings.Fields(value) {
+		emitter.Emit(w, "1")
+	}
+}
+
+func (wordJob) MapFinal(emitter Emitter) {}
+
+func (wordJob) Reduce(key string, values []string, emitter Emitter) {
+	var sum int
+	for _, v := range values {
+		n, _ := strconv.Atoi(v)
+		sum += n
+	}
+	emitter.Emit(key, strconv.Itoa(sum))
+}
+
+// inTempDir runs the rest of the test in a new temporary directory, where a
+// standalone run leaves its files
+func inTempDir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// captureStdout sends os.Stdout to a file until the test ends, and returns a
+// function which reads what has been written so far
+func captureStdout(t *testing.T) func() string {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := os.Stdout
+	os.Stdout = f
+	t.Cleanup(func() {
+		os.Stdout = old
+		f.Close()
+	})
+
+	return func() string {
+		b, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+// keepRunFlags puts back the flags RunLocalFiles sets from its options when the test ends
+func keepRunFlags(tb testing.TB) {
+	for _, name := range []string{"partitions", "reducers", "mappers"} {
+		setFlag(tb, name, flagSet.Lookup(name).Value.String())
+	}
+}
+
+// runLocal writes each input to a file in a temporary directory, runs the job
+// over them with RunLocalFiles, and returns the result and the output lines, in
+// partition order
+func runLocal(t *testing.T, mrjob MapReduceJob, inputs []string, opts *RunOptions) (*RunResult, []string, error) {
+	t.Helper()
+
+	quietStderr(t)
+	inTempDir(t)
+	keepRunFlags(t)
+
+	var fnames []string
+	for i, s := range inputs {
+		fname := fmt.Sprintf("input%d", i)
+		if err := ioutil.WriteFile(fname, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fnames = append(fnames, fname)
+	}
+
+	res, err := RunLocalFiles(mrjob, fnames, opts)
+	if res == nil {
+		return nil, nil, err
+	}
+
+	var lines []string
+	for _, fname := range res.Outputs {
+		b, rerr := ioutil.ReadFile(fname)
+		if os.IsNotExist(rerr) && err != nil {
+			// a failed partition's output isn't committed
+			continue
+		}
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		if len(b) == 0 {
+			continue
+		}
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")...)
+	}
+
+	return res, lines, err
+}
+
+// sortedLines returns a sorted copy of lines, for output from several partitions
+func sortedLines(lines []string) []string {
+	lines = append([]string(nil), lines...)
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,154 @@
+package dmrgo
+
+// Approximate distinct counts with HyperLogLog
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"encoding/base64"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog estimates the number of distinct strings added to it (Flajolet
+// et al.), using 2^precision bytes of memory however many are added.  The
+// standard error is about 1.04/sqrt(2^precision).  HyperLogLog is not safe for
+// concurrent use.
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// NewHyperLogLog returns a HyperLogLog with the given precision, clamped to 4-18.
+// 14 (16KB, about 0.8% error) is a good default.
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	if precision < 4 {
+		precision = 4
+	}
+	if precision > 18 {
+		precision = 18
+	}
+	return &HyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// Add adds s to the set
+func (h *HyperLogLog) Add(s string) {
+
+	x := hllHash(s)
+
+	p := uint(h.precision)
+	idx := x >> (64 - p)
+	// the bit at p-1 bounds the run of zeros for an all-zero remainder
+	rho := uint8(bits.LeadingZeros64(x<<p|1<<(p-1))) + 1
+
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+// Count returns the estimated number of distinct strings added
+func (h *HyperLogLog) Count() uint64 {
+
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	e := alpha * m * m / sum
+
+	// small range correction: linear counting
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(e + 0.5)
+}
+
+// Precision returns the precision the HyperLogLog was created with
+func (h *HyperLogLog) Precision() uint8 {
+	return h.precision
+}
+
+// ErrPrecisionMismatch is returned when merging HyperLogLogs of different precisions
+var ErrPrecisionMismatch = errors.New("dmrgo: HyperLogLog precisions differ")
+
+// Merge adds all the strings added to other into h, so h estimates the size of
+// their union.  This lets combiners aggregate partial counts.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+
+	if h.precision != other.precision {
+		return ErrPrecisionMismatch
+	}
+
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, so a HyperLogLog can be
+// emitted through JSONProtocol.  The registers are encoded in base64.
+func (h *HyperLogLog) MarshalText() ([]byte, error) {
+	b := append([]byte{h.precision}, h.registers...)
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(out, b)
+	return out, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (h *HyperLogLog) UnmarshalText(text []byte) error {
+
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(b, text)
+	if err != nil {
+		return err
+	}
+	b = b[:n]
+
+	if len(b) == 0 || b[0] < 4 || b[0] > 18 || len(b)-1 != 1<<b[0] {
+		return errors.New("dmrgo: bad HyperLogLog encoding")
+	}
+
+	h.precision = b[0]
+	h.registers = b[1:]
+
+	return nil
+}
+
+// hllHash hashes s with FNV-1a, then mixes the bits (with MurmurHash3's
+// finalizer), since HyperLogLog needs all of them to be well distributed.
+// The hash must be the same in every process for registers to be mergeable.
+func hllHash(s string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	x := f.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
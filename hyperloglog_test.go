@@ -0,0 +1,56 @@
+package dmrgo
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLogAccuracy(t *testing.T) {
+
+	for _, tt := range []struct {
+		precision uint8
+		n         int
+	}{
+		{10, 1000},
+		{14, 100},
+		{14, 10000},
+		{14, 1000000},
+	} {
+		h := NewHyperLogLog(tt.precision)
+		for i := 0; i < tt.n; i++ {
+			h.Add(fmt.Sprintf("item-%d", i))
+			// duplicates don't count
+			h.Add(fmt.Sprintf("item-%d", i/2))
+		}
+
+		// allow four standard errors
+		stderr := 1.04 / math.Sqrt(float64(uint64(1)<<tt.precision))
+		got := float64(h.Count())
+		if e := math.Abs(got-float64(tt.n)) / float64(tt.n); e > 4*stderr {
+			t.Errorf("precision %d: counted %v of %d distinct, an error of %.2f%%, more than %.2f%%", tt.precision, got, tt.n, 100*e, 400*stderr)
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+
+	a, b := NewHyperLogLog(14), NewHyperLogLog(14)
+	for i := 0; i < 20000; i++ {
+		a.Add(fmt.Sprint(i))
+		b.Add(fmt.Sprint(i + 10000))
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the union is 30000
+	if n := a.Count(); n < 29000 || n > 31000 {
+		t.Errorf("merged count=%d, want about 30000", n)
+	}
+
+	if err := a.Merge(NewHyperLogLog(10)); err != ErrPrecisionMismatch {
+		t.Errorf("merging a different precision gave %v, want ErrPrecisionMismatch", err)
+	}
+}
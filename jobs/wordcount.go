@@ -0,0 +1,45 @@
+// Package jobs contains ready-made map/reduce jobs
+package jobs
+
+// Counting words
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"strconv"
+
+	"github.com/dgryski/dmrgo"
+)
+
+// WordCount counts the occurrences of each word in its input, as split by
+// dmrgo.Tokenize.  Counts are emitted as plain decimal numbers, keyed by the word.
+type WordCount struct{}
+
+// Map implements the MapReduceJob interface
+func (*WordCount) Map(key string, value string, emitter dmrgo.Emitter) {
+	for _, word := range dmrgo.Tokenize(value) {
+		emitter.Emit(word, "1")
+	}
+}
+
+// MapFinal implements the MapReduceJob interface
+func (*WordCount) MapFinal(emitter dmrgo.Emitter) {}
+
+// MergeValues implements the ValueMerger interface, summing counts before the shuffle
+func (wc *WordCount) MergeValues(a, b string) string {
+	return strconv.Itoa(sum([]string{a, b}))
+}
+
+// Reduce implements the MapReduceJob interface
+func (*WordCount) Reduce(key string, values []string, emitter dmrgo.Emitter) {
+	emitter.Emit(key, strconv.Itoa(sum(values)))
+}
+
+func sum(values []string) int {
+	var total int
+	for _, v := range values {
+		n, _ := strconv.Atoi(v)
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,125 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dgryski/dmrgo"
+)
+
+// runWordCount runs the job over the inputs with the standalone map/reduce, in
+// a temporary directory, and returns the counts it wrote
+func runWordCount(t *testing.T, job dmrgo.MapReduceJob, inputs ...string) map[string]string {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// the counters and status go to stderr
+	stderr := os.Stderr
+	if null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stderr = null
+		defer func() { os.Stderr = stderr; null.Close() }()
+	}
+
+	var fnames []string
+	for i, s := range inputs {
+		fname := "input" + string(rune('0'+i))
+		if err := ioutil.WriteFile(fname, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fnames = append(fnames, fname)
+	}
+
+	res, err := dmrgo.RunLocalFiles(job, fnames, &dmrgo.RunOptions{Partitions: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]string)
+	for _, fname := range res.Outputs {
+		b, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			if kv := strings.SplitN(line, "\t", 2); len(kv) == 2 {
+				counts[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	return counts
+}
+
+func TestWordCount(t *testing.T) {
+
+	counts := runWordCount(t, new(WordCount),
+		"The quick brown fox\njumps over the lazy dog.\n",
+		"the dog, the FOX -- and über Über\n",
+	)
+
+	want := map[string]string{
+		"the": "4", "quick": "1", "brown": "1", "fox": "2", "jumps": "1",
+		"over": "1", "lazy": "1", "dog": "2", "and": "1", "über": "2",
+	}
+
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counted %v, want %v", counts, want)
+	}
+}
+
+// loggingJob wraps a job, recording the keys it reduces
+type loggingJob struct {
+	dmrgo.JobWrapper
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func (j *loggingJob) Reduce(key string, values []string, emitter dmrgo.Emitter) {
+	j.mu.Lock()
+	j.keys = append(j.keys, key)
+	j.mu.Unlock()
+	j.JobWrapper.Reduce(key, values, emitter)
+}
+
+func TestLoggingWordCount(t *testing.T) {
+
+	input := "one two two\nthree three three\n"
+
+	job := &loggingJob{JobWrapper: dmrgo.JobWrapper{MapReduceJob: new(WordCount)}}
+	counts := runWordCount(t, job, input)
+
+	if want := map[string]string{"one": "1", "two": "2", "three": "3"}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("counted %v, want %v", counts, want)
+	}
+
+	sort.Strings(job.keys)
+	if want := []string{"one", "three", "two"}; !reflect.DeepEqual(job.keys, want) {
+		t.Errorf("logged %q, want %q", job.keys, want)
+	}
+
+	// swapping the mapper keeps the wrapped reducer
+	upper := dmrgo.WithMapper(new(WordCount), func(key string, value string, emitter dmrgo.Emitter) {
+		for _, word := range strings.Fields(value) {
+			emitter.Emit(strings.ToUpper(word), "1")
+		}
+	})
+	counts = runWordCount(t, upper, input)
+
+	if want := map[string]string{"ONE": "1", "TWO": "2", "THREE": "3"}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("with the mapper swapped, counted %v, want %v", counts, want)
+	}
+}
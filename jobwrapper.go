@@ -0,0 +1,60 @@
+package dmrgo
+
+// Wrapping and composing jobs
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+// JobWrapper passes all calls on to the job it wraps.  Embed it in a struct
+// and define just the methods you want to change, such as to add logging
+// around Reduce.  ReduceFinal, NormalizeKey and StableOrder are passed on too,
+// if the wrapped job has them; other optional interfaces (Combiner, ValueMerger,
+// ValueSorter) are not, so the wrapper must define those itself if it wants
+// them.  Nor are ReduceFields and ReduceLazy, as the runner would call them in
+// place of the wrapper's Reduce: a wrapped FieldReducer or LazyReducer is
+// reduced with its plain Reduce, unless the wrapper defines them too.
+type JobWrapper struct {
+	MapReduceJob
+}
+
+// ReduceFinal implements the ReduceFinalizer interface
+func (w *JobWrapper) ReduceFinal(emitter Emitter) {
+	reducerFinal(w.MapReduceJob, emitter)
+}
+
+// NormalizeKey implements the KeyNormalizer interface
+func (w *JobWrapper) NormalizeKey(key string) string {
+	return normalizeKey(w.MapReduceJob, key)
+}
+
+// StableOrder implements the StableOrderer interface
+func (w *JobWrapper) StableOrder() bool {
+	return isStable(w.MapReduceJob)
+}
+
+type mapperJob struct {
+	JobWrapper
+	mapFn func(key string, value string, emitter Emitter)
+}
+
+func (j *mapperJob) Map(key string, value string, emitter Emitter) {
+	j.mapFn(key, value, emitter)
+}
+
+// WithMapper returns job with its Map replaced by mapFn
+func WithMapper(job MapReduceJob, mapFn func(key string, value string, emitter Emitter)) MapReduceJob {
+	return &mapperJob{JobWrapper{job}, mapFn}
+}
+
+type reducerJob struct {
+	JobWrapper
+	reduceFn func(key string, values []string, emitter Emitter)
+}
+
+func (j *reducerJob) Reduce(key string, values []string, emitter Emitter) {
+	j.reduceFn(key, values, emitter)
+}
+
+// WithReducer returns job with its Reduce replaced by reduceFn
+func WithReducer(job MapReduceJob, reduceFn func(key string, values []string, emitter Emitter)) MapReduceJob {
+	return &reducerJob{JobWrapper{job}, reduceFn}
+}
@@ -0,0 +1,45 @@
+package dmrgo
+
+// Sorting a key's values only when the reducer asks
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"sort"
+)
+
+// LazyValues holds the values for a key, and sorts them only if asked.  It is
+// meant for reducers which only sometimes need their values in order.
+type LazyValues struct {
+	values []string
+}
+
+// NewLazyValues wraps the values for a key
+func NewLazyValues(values []string) *LazyValues {
+	return &LazyValues{values: values}
+}
+
+// Values returns the values in the order they were read
+func (v *LazyValues) Values() []string {
+	return v.values
+}
+
+// Len returns the number of values
+func (v *LazyValues) Len() int {
+	return len(v.values)
+}
+
+// Sorted returns a copy of the values, sorted with less.  Each call sorts a
+// new copy, so reducers needing the same order more than once should keep the
+// result.
+func (v *LazyValues) Sorted(less func(a, b string) bool) []string {
+	sorted := append([]string(nil), v.values...)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	return sorted
+}
+
+// LazyReducer can be implemented by jobs which want their values as a
+// LazyValues.  The runner calls ReduceLazy in place of Reduce.
+type LazyReducer interface {
+	ReduceLazy(key string, values *LazyValues, emitter Emitter)
+}
@@ -0,0 +1,76 @@
+package dmrgo
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lazyJob emits the largest value of keys starting with "max", comparing
+// numerically, and the values as read for the others
+type lazyJob struct {
+	wordJob
+	sorts *int
+}
+
+func (j lazyJob) ReduceLazy(key string, values *LazyValues, emitter Emitter) {
+
+	if !strings.HasPrefix(key, "max") {
+		emitter.Emit(key, strings.Join(values.Values(), ","))
+		return
+	}
+
+	numeric := func(a, b string) bool {
+		*j.sorts++
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x < y
+	}
+
+	sorted := values.Sorted(numeric)
+	emitter.Emit(key, sorted[values.Len()-1])
+}
+
+func TestLazyValues(t *testing.T) {
+
+	v := NewLazyValues([]string{"10", "9", "100"})
+
+	var calls int
+	numeric := func(a, b string) bool {
+		calls++
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x < y
+	}
+
+	if got, want := v.Sorted(numeric), []string{"9", "10", "100"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sorted gave %q, want %q", got, want)
+	}
+
+	// each call sorts with its own less, and doesn't disturb the values as read
+	if got, want := v.Sorted(func(a, b string) bool { return a < b }), []string{"10", "100", "9"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sorted by string gave %q, want %q", got, want)
+	}
+	if got, want := v.Sorted(func(a, b string) bool { return len(a) > len(b) }), []string{"100", "10", "9"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sorted by length gave %q, want %q", got, want)
+	}
+	if got, want := v.Values(), []string{"10", "9", "100"}; !reflect.DeepEqual(got, want) || v.Len() != 3 {
+		t.Errorf("Values gave %q, want %q", got, want)
+	}
+
+	// the runner calls ReduceLazy in place of Reduce
+	kvs := []KeyValue{{"max", "10"}, {"max", "9"}, {"max", "100"}, {"other", "b"}, {"other", "a"}}
+
+	var sorts int
+	var e SliceEmitter
+	reduceRecords(lazyJob{sorts: &sorts}, &sliceRecordReader{kvs}, &e)
+
+	want := []KeyValue{{"max", "100"}, {"other", "b,a"}}
+	if !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced %v, want %v", e.KeyValues, want)
+	}
+	if sorts == 0 {
+		t.Errorf("the max group was never sorted")
+	}
+}
@@ -0,0 +1,75 @@
+package dmrgo
+
+// Output manifest for standalone map/reduce runs
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// where to write the output manifest, if anywhere
+var optManifest string
+
+// OutputFile describes one reducer output file
+type OutputFile struct {
+	Path      string `json:"path"`
+	Partition int    `json:"partition"`
+	Records   int64  `json:"records"`
+	Bytes     int64  `json:"bytes"`
+	MinKey    string `json:"min_key"`
+	MaxKey    string `json:"max_key"`
+}
+
+// Manifest describes the output of a standalone map/reduce run.
+// Records is the total across all output files.  SortTime and ReduceTime are
+// summed across all partitions.
+type Manifest struct {
+	Files      []OutputFile  `json:"files"`
+	Mappers    int           `json:"mappers"`
+	Records    int64         `json:"records"`
+	WallTime   time.Duration `json:"wall_time_ns"`
+	MapTime    time.Duration `json:"map_time_ns"`
+	SortTime   time.Duration `json:"sort_time_ns"`
+	ReduceTime time.Duration `json:"reduce_time_ns"`
+}
+
+// statsEmitter wraps an emitter and tracks the records passing through it
+type statsEmitter struct {
+	Emitter
+	records int64
+	minKey  string
+	maxKey  string
+}
+
+func (e *statsEmitter) Emit(key string, value string) {
+	if e.records == 0 || key < e.minKey {
+		e.minKey = key
+	}
+	if e.records == 0 || key > e.maxKey {
+		e.maxKey = key
+	}
+	e.records++
+	e.Emitter.Emit(key, value)
+}
+
+func writeManifest(path string, m *Manifest) error {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err == nil {
+		_, err = f.Write(b)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,134 @@
+package dmrgo
+
+// Handling NaN and infinite floats in the protocols
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// what the TSV and JSON protocols do with NaN and infinite floats, which JSON
+// can't represent: report them as bad records and write null ("error"), write
+// null ("null"), or write the -nonfinite-sentinel value instead ("sentinel").
+// TSV writes an empty field for null, which reads back as zero.
+var optNonFinite string
+var optNonFiniteSentinel float64
+
+func isNonFinite(f float64) bool {
+	return math.IsNaN(f) || math.IsInf(f, 0)
+}
+
+// nonFinite applies the -nonfinite policy to f.  It returns the value to write,
+// or false if null should be written instead.
+func nonFinite(f float64) (float64, bool) {
+	switch optNonFinite {
+	case "sentinel":
+		return optNonFiniteSentinel, true
+	case "null":
+		return 0, false
+	default:
+		badRecord("non-finite floats", "can't marshal %v", f)
+		return 0, false
+	}
+}
+
+// nonFiniteString returns the TSV field for a non-finite float
+func nonFiniteString(f float64) string {
+	if s, ok := nonFinite(f); ok {
+		return strconv.FormatFloat(s, 'g', 5, 64)
+	}
+	return ""
+}
+
+// marshalJSON marshals v as JSON, applying the -nonfinite policy to any NaN or
+// infinite floats it contains.
+func marshalJSON(v interface{}) []byte {
+
+	b, err := json.Marshal(v)
+	if _, ok := err.(*json.UnsupportedValueError); !ok {
+		return b
+	}
+
+	// only the slow path rebuilds the value
+	b, _ = json.Marshal(replaceNonFinite(reflect.ValueOf(v)))
+	return b
+}
+
+// replaceNonFinite returns a copy of v with its non-finite floats replaced, as
+// generic maps and slices.  Structs become maps keyed by their JSON field names;
+// embedded structs are not flattened.
+func replaceNonFinite(v reflect.Value) interface{} {
+
+	switch v.Kind() {
+
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if !isNonFinite(f) {
+			return f
+		}
+		if s, ok := nonFinite(f); ok {
+			return s
+		}
+		return nil
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return replaceNonFinite(v.Elem())
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		s := make([]interface{}, v.Len())
+		for i := range s {
+			s[i] = replaceNonFinite(v.Index(i))
+		}
+		return s
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			// JSON object keys are strings
+			m[fmt.Sprint(k.Interface())] = replaceNonFinite(v.MapIndex(k))
+		}
+		return m
+
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			m[name] = replaceNonFinite(v.Field(i))
+		}
+		return m
+	}
+
+	if !v.IsValid() {
+		return nil
+	}
+
+	return v.Interface()
+}
@@ -0,0 +1,59 @@
+package dmrgo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNonFinite(t *testing.T) {
+
+	quietStderr(t)
+
+	setFlag(t, "on-bad-record", "count")
+	setFlag(t, "nonfinite-sentinel", "-1")
+
+	type reading struct {
+		Name  string
+		Value float64
+	}
+
+	for _, policy := range []string{"error", "null", "sentinel"} {
+
+		setFlag(t, "nonfinite", policy)
+
+		for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+
+			// what reading the value back should give: null and an empty TSV field read as zero
+			want := 0.0
+			if policy == "sentinel" {
+				want = -1
+			}
+
+			for _, p := range []StreamProtocol{new(JSONProtocol), new(TSVProtocol)} {
+
+				resetCounters()
+
+				kv := p.MarshalKV("k", reading{"x", f})
+
+				var k string
+				var vs []reading
+				p.UnmarshalKVs(kv.Key, []string{kv.Value}, &k, &vs)
+
+				if len(vs) != 1 || vs[0].Name != "x" || vs[0].Value != want {
+					t.Errorf("-nonfinite %s, %T: %v was written as %q, and read back as %+v, want %v", policy, p, f, kv.Value, vs, want)
+				}
+
+				bad := counterTotals()["dmrgo"]["non-finite floats"]
+				if policy == "error" && bad != 1 || policy != "error" && bad != 0 {
+					t.Errorf("-nonfinite %s, %T: %v counted %d bad records", policy, p, f, bad)
+				}
+			}
+		}
+	}
+
+	// finite values are untouched
+	setFlag(t, "nonfinite", "sentinel")
+	if kv := new(JSONProtocol).MarshalKV("k", 1.5); kv.Value != "1.5" {
+		t.Errorf("1.5 was written as %q", kv.Value)
+	}
+}
@@ -0,0 +1,218 @@
+package dmrgo
+
+// Logic for assigning keys to partitions
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Partitioner decides which partition a key belongs to
+type Partitioner interface {
+	// Partition returns a value in [0, partitions)
+	Partition(key string, partitions uint32) uint32
+}
+
+// HashPartitionerVersion identifies the hash used by HashPartitioner.  Keys
+// are assigned to the same partitions by every release with the same version.
+const HashPartitionerVersion = 1
+
+// HashPartitioner assigns keys to partitions by their adler32 checksum.  The
+// checksum is computed here rather than with hash/adler32, so the assignment
+// is fixed by HashPartitionerVersion and not by the Go release.
+type HashPartitioner struct{}
+
+// Partition implements the Partitioner interface
+func (*HashPartitioner) Partition(key string, partitions uint32) uint32 {
+	if partitions <= 1 {
+		return 0
+	}
+	return partitionHashV1(key) % partitions
+}
+
+// partitionHashV1 is the adler32 checksum of key, as defined by RFC 1950
+func partitionHashV1(key string) uint32 {
+
+	const mod = 65521
+
+	a, b := uint32(1), uint32(0)
+
+	for i := 0; i < len(key); i++ {
+		a = (a + uint32(key[i])) % mod
+		b = (b + a) % mod
+	}
+
+	return b<<16 | a
+}
+
+// RangePartitioner assigns keys to partitions by key range, so the partitions
+// are in key order.  Boundaries must be sorted: partition 0 holds the keys
+// before Boundaries[0], partition i the keys from Boundaries[i-1] up to but not
+// including Boundaries[i], and the last partition the rest.  There should be
+// one less boundary than partitions; keys past the last partition go in it.
+type RangePartitioner struct {
+	Boundaries []string
+}
+
+// Partition implements the Partitioner interface
+func (r *RangePartitioner) Partition(key string, partitions uint32) uint32 {
+
+	p := uint32(sort.Search(len(r.Boundaries), func(i int) bool { return r.Boundaries[i] > key }))
+
+	if p >= partitions {
+		p = partitions - 1
+	}
+
+	return p
+}
+
+// ConsistentHashPartitioner assigns keys to partitions with a hash ring, so
+// changing the number of partitions moves few keys: going from n to n+1
+// partitions moves about 1/(n+1) of them, all to the new partition.  Each
+// partition has Replicas points on the ring (100 if zero); more points spread
+// the keys more evenly.  The assignment depends only on the number of
+// partitions and Replicas.  The zero value is ready to use, and it is safe for
+// concurrent use.  Pass it to SetPartitioner to use it for a run.
+type ConsistentHashPartitioner struct {
+	Replicas int
+
+	mu    sync.Mutex
+	rings map[uint32]*hashRing
+}
+
+// the number of points on the ring per partition, if Replicas isn't set
+const defaultReplicas = 100
+
+// hashRing is the sorted points of a ring, and the partition each belongs to
+type hashRing struct {
+	points     []uint64
+	partitions []uint32
+}
+
+// Partition implements the Partitioner interface
+func (c *ConsistentHashPartitioner) Partition(key string, partitions uint32) uint32 {
+
+	if partitions <= 1 {
+		return 0
+	}
+
+	r := c.ring(partitions)
+
+	h := hllHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		// past the last point, so wrap around to the first
+		i = 0
+	}
+
+	return r.partitions[i]
+}
+
+// ring returns the ring for the number of partitions, building it if needed
+func (c *ConsistentHashPartitioner) ring(partitions uint32) *hashRing {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, ok := c.rings[partitions]; ok {
+		return r
+	}
+
+	replicas := c.Replicas
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+
+	type point struct {
+		h         uint64
+		partition uint32
+	}
+
+	points := make([]point, 0, int(partitions)*replicas)
+	for p := uint32(0); p < partitions; p++ {
+		for i := 0; i < replicas; i++ {
+			points = append(points, point{hllHash(fmt.Sprintf("%d-%d", p, i)), p})
+		}
+	}
+
+	// ties (vanishingly unlikely) go to the lower partition, so the ring is deterministic
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].h != points[j].h {
+			return points[i].h < points[j].h
+		}
+		return points[i].partition < points[j].partition
+	})
+
+	r := &hashRing{make([]uint64, len(points)), make([]uint32, len(points))}
+	for i, pt := range points {
+		r.points[i] = pt.h
+		r.partitions[i] = pt.partition
+	}
+
+	if c.rings == nil {
+		c.rings = make(map[uint32]*hashRing)
+	}
+	c.rings[partitions] = r
+
+	return r
+}
+
+// the partitioner used by the standalone map/reduce
+var partitioner Partitioner = new(HashPartitioner)
+
+// SetPartitioner sets the Partitioner the standalone map/reduce uses to assign
+// keys to partitions, and -verify-partitions checks against; nil restores the
+// default HashPartitioner.  Call it before Main or RunLocalFiles.
+func SetPartitioner(p Partitioner) {
+	if p == nil {
+		p = new(HashPartitioner)
+	}
+	partitioner = p
+}
+
+// PartitionKeyFunc, if set, derives the key used to choose a partition from the
+// full key.  The full key is still written to the partition's file, and is what
+// the reducer sorts and groups on.  With composite keys like "user#timestamp",
+// returning just the prefix sends all of a user's records to the same reducer.
+var PartitionKeyFunc func(key string) string
+
+// partitionOf returns the partition the key belongs to
+func partitionOf(key string, partitions uint32) uint32 {
+	if PartitionKeyFunc != nil {
+		key = PartitionKeyFunc(key)
+	}
+	return partitioner.Partition(key, partitions)
+}
+
+// partitionVerifier checks that every key the reducer reads actually belongs to
+// the partition being reduced.  It checks the key as it was written, before any
+// KeyNormalizer sees it, and counts each mismatched key once.
+type partitionVerifier struct {
+	r          RecordReader
+	partition  uint32
+	partitions uint32
+
+	// the last key checked
+	last    string
+	checked bool
+}
+
+func (v *partitionVerifier) ReadRecord() (*KeyValue, error) {
+
+	kv, err := v.r.ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	if !v.checked || kv.Key != v.last {
+		if partitionOf(kv.Key, v.partitions) != v.partition {
+			IncrCounter("dmrgo", "partition mismatches", 1)
+		}
+		v.last, v.checked = kv.Key, true
+	}
+
+	return kv, nil
+}
@@ -0,0 +1,183 @@
+package dmrgo
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The assignments below are fixed by HashPartitionerVersion: if one changes,
+// the version must too, as data partitioned by an earlier release would no
+// longer line up.
+func TestHashPartitionerGolden(t *testing.T) {
+
+	if HashPartitionerVersion != 1 {
+		t.Fatalf("HashPartitionerVersion=%d, but the golden values are for version 1", HashPartitionerVersion)
+	}
+
+	tests := []struct {
+		key   string
+		hash  uint32
+		part2 uint32
+		part7 uint32
+	}{
+		{"", 0x00000001, 1, 1},
+		{"a", 0x00620062, 0, 0},
+		{"b", 0x00630063, 1, 3},
+		{"hello", 0x062c0215, 1, 4},
+		{"Hello", 0x058c01f5, 1, 2},
+		{"user#1", 0x08640214, 0, 5},
+		{"the quick brown fox", 0x478e0734, 0, 1},
+		{"\x00\xff", 0x01010100, 0, 0},
+	}
+
+	p := new(HashPartitioner)
+
+	for _, tt := range tests {
+		if h := partitionHashV1(tt.key); h != tt.hash {
+			t.Errorf("partitionHashV1(%q)=%#08x, want %#08x", tt.key, h, tt.hash)
+		}
+		if got := p.Partition(tt.key, 1); got != 0 {
+			t.Errorf("Partition(%q, 1)=%d, want 0", tt.key, got)
+		}
+		if got := p.Partition(tt.key, 2); got != tt.part2 {
+			t.Errorf("Partition(%q, 2)=%d, want %d", tt.key, got, tt.part2)
+		}
+		if got := p.Partition(tt.key, 7); got != tt.part7 {
+			t.Errorf("Partition(%q, 7)=%d, want %d", tt.key, got, tt.part7)
+		}
+	}
+}
+
+func TestPartitionVerifier(t *testing.T) {
+
+	quietStderr(t)
+
+	// "a" and "user#1" belong in partition 0 of 2, "b" in partition 1
+	kvs := []KeyValue{{"a", "1"}, {"b", "1"}, {"b", "2"}, {"user#1", "1"}}
+
+	resetCounters()
+
+	r := &partitionVerifier{r: &sliceRecordReader{kvs}, partition: 0, partitions: 2}
+	var e SliceEmitter
+	reduceRecords(wordJob{}, r, &e)
+
+	if got := counterTotals()["dmrgo"]["partition mismatches"]; got != 1 {
+		t.Errorf("partition mismatches=%d, want 1 for the misplaced key", got)
+	}
+
+	// SetPartitioner changes what the reducer checks against
+	SetPartitioner(&RangePartitioner{[]string{"c"}})
+	defer SetPartitioner(nil)
+
+	resetCounters()
+
+	r = &partitionVerifier{r: &sliceRecordReader{kvs}, partition: 0, partitions: 2}
+	reduceRecords(wordJob{}, r, &e)
+
+	if got := counterTotals()["dmrgo"]["partition mismatches"]; got != 1 {
+		t.Errorf("with a RangePartitioner, partition mismatches=%d, want 1 for \"user#1\"", got)
+	}
+}
+
+func TestPartitionKeyFunc(t *testing.T) {
+
+	if PartitionKeyFunc != nil {
+		t.Fatalf("PartitionKeyFunc isn't nil (the identity) by default")
+	}
+
+	// without a PartitionKeyFunc, the keys are spread by their whole key
+	var differ bool
+	for partitions := uint32(2); partitions <= 16; partitions++ {
+		if partitionOf("a#1", partitions) != partitionOf("a#2", partitions) {
+			differ = true
+		}
+	}
+	if !differ {
+		t.Fatalf("a#1 and a#2 always share a partition, so the test proves nothing")
+	}
+
+	PartitionKeyFunc = func(key string) string { return strings.SplitN(key, "#", 2)[0] }
+	defer func() { PartitionKeyFunc = nil }()
+
+	for partitions := uint32(2); partitions <= 16; partitions++ {
+		want := partitionOf("a", partitions)
+		if p1, p2 := partitionOf("a#1", partitions), partitionOf("a#2", partitions); p1 != want || p2 != want {
+			t.Errorf("%d partitions: a#1 in %d, a#2 in %d, want both in %d", partitions, p1, p2, want)
+		}
+	}
+
+	// the partition files still hold the full keys
+	pe := newPartitionEmitter(nil, 4, filepath.Join(t.TempDir(), "map-out"))
+	pe.Emit("a#1", "x")
+	pe.Emit("a#2", "y")
+	pe.Close()
+
+	contents := partitionContents(t, pe)
+	if got := contents[partitionOf("a", 4)]; got != "a#1\tx\na#2\ty\n" {
+		t.Errorf("a's partition has %q, want both full keys", got)
+	}
+}
+
+func TestConsistentHashPartitioner(t *testing.T) {
+
+	const keys = 10000
+
+	c := new(ConsistentHashPartitioner)
+
+	for partitions := uint32(2); partitions <= 8; partitions++ {
+
+		var moved int
+		counts := make([]int, partitions+1)
+
+		for i := 0; i < keys; i++ {
+			key := fmt.Sprintf("key%d", i)
+			before, after := c.Partition(key, partitions), c.Partition(key, partitions+1)
+			counts[after]++
+			if before != after {
+				moved++
+				if after != partitions {
+					t.Fatalf("%q moved from %d to %d going to %d partitions, not to the new partition", key, before, after, partitions+1)
+				}
+			}
+		}
+
+		// about 1/(n+1) of the keys should move; allow for the ring's unevenness
+		if expected := keys / int(partitions+1); moved > 2*expected || moved < expected/2 {
+			t.Errorf("going from %d to %d partitions moved %d keys, want about %d", partitions, partitions+1, moved, expected)
+		}
+
+		for p, n := range counts {
+			if n == 0 {
+				t.Errorf("with %d partitions, partition %d got no keys", partitions+1, p)
+			}
+		}
+	}
+
+	// a new partitioner assigns the keys the same way
+	if a, b := c.Partition("hello", 5), new(ConsistentHashPartitioner).Partition("hello", 5); a != b {
+		t.Errorf("two partitioners put \"hello\" in %d and %d", a, b)
+	}
+
+	// and the reducers of a run with it check keys against it
+	SetPartitioner(c)
+	defer SetPartitioner(nil)
+
+	setFlag(t, "verify-partitions", "true")
+	resetCounters()
+
+	_, lines, err := runLocal(t, wordJob{}, []string{"a b c\n", "c b c\n"}, &RunOptions{Partitions: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a\t1", "b\t2", "c\t3"}; !reflect.DeepEqual(sortedLines(lines), want) {
+		t.Errorf("reduced %q, want %q", sortedLines(lines), want)
+	}
+
+	if got := counterTotals()["dmrgo"]["partition mismatches"]; got != 0 {
+		t.Errorf("partition mismatches=%d, want none", got)
+	}
+}
@@ -0,0 +1,63 @@
+package dmrgo
+
+// Writing CPU and memory profiles
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// where to write the pprof profiles, if anywhere
+var optCPUProfile string
+var optMemProfile string
+
+// startProfiling starts the CPU profile, if one was asked for.  The returned
+// function stops it, and writes the heap profile.
+func startProfiling() func() {
+
+	var cpu *os.File
+
+	if optCPUProfile != "" {
+		f, err := os.Create(optCPUProfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "err creating cpu profile: ", err)
+		} else if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Fprintln(os.Stderr, "err starting cpu profile: ", err)
+			f.Close()
+		} else {
+			cpu = f
+		}
+	}
+
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+
+		if optMemProfile != "" {
+			writeMemProfile(optMemProfile)
+		}
+	}
+}
+
+func writeMemProfile(fname string) {
+
+	f, err := os.Create(fname)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "err creating memory profile: ", err)
+		return
+	}
+	defer f.Close()
+
+	// get up-to-date statistics
+	runtime.GC()
+
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		fmt.Fprintln(os.Stderr, "err writing memory profile: ", err)
+	}
+}
@@ -6,10 +6,12 @@ package dmrgo
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // StreamProtocol is a set of routines for marshaling and unmarshaling key/value pairs from the input stream.
@@ -25,7 +27,118 @@ type StreamProtocol interface {
 	MarshalKV(key interface{}, value interface{}) *KeyValue
 }
 
-// JSONProtocol parse input/output values as JSON strings
+// KeyMarshaler can be implemented by protocols which can marshal a key on its
+// own, such as for choosing its partition, without needing a value as well
+type KeyMarshaler interface {
+	MarshalKey(key interface{}) string
+}
+
+// MarshalKey returns the key as the protocol would marshal it.  Protocols which
+// aren't KeyMarshalers marshal the key with a nil value.
+func MarshalKey(p StreamProtocol, key interface{}) string {
+	if m, ok := p.(KeyMarshaler); ok {
+		return m.MarshalKey(key)
+	}
+	return p.MarshalKV(key, nil).Key
+}
+
+// the registered protocols, by name
+var protocolsMu sync.RWMutex
+var protocols = map[string]func() StreamProtocol{
+	"json": func() StreamProtocol { return new(JSONProtocol) },
+	"tsv":  func() StreamProtocol { return new(TSVProtocol) },
+
+	"sortable-json": func() StreamProtocol { return new(SortableJSONProtocol) },
+	"querystring":   func() StreamProtocol { return new(QueryStringProtocol) },
+	"typedbytes":    func() StreamProtocol { return new(TypedBytesProtocol) },
+}
+
+// RegisterProtocol makes a protocol available by name to ProtocolByName.
+// It panics if a protocol with the same name is already registered.
+func RegisterProtocol(name string, factory func() StreamProtocol) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	if _, dup := protocols[name]; dup {
+		panic("dmrgo: RegisterProtocol called twice for " + name)
+	}
+	protocols[name] = factory
+}
+
+// ProtocolByName returns a new instance of the named protocol
+func ProtocolByName(name string) (StreamProtocol, error) {
+	protocolsMu.RLock()
+	f, ok := protocols[name]
+	protocolsMu.RUnlock()
+	if !ok {
+		return nil, errors.New("dmrgo: unknown protocol " + strconv.Quote(name))
+	}
+	return f(), nil
+}
+
+// Protocols are the protocols for each stage of a job: parsing the map input,
+// the intermediate map output/reduce input, and the reduce output.
+type Protocols struct {
+	Input        StreamProtocol
+	Intermediate StreamProtocol
+	Output       StreamProtocol
+}
+
+// names of the protocols for each stage, from the command line
+var optInputProto string
+var optIntermediateProto string
+var optOutputProto string
+
+// JobProtocols returns the protocols named by -input-proto, -intermediate-proto
+// and -output-proto.  Stages without a protocol of their own use the first of
+// those that was given, or JSON if none were.
+func JobProtocols() (*Protocols, error) {
+
+	def := defaultProtoName()
+
+	ps := new(Protocols)
+
+	for _, s := range []struct {
+		p    *StreamProtocol
+		name string
+	}{
+		{&ps.Input, optInputProto},
+		{&ps.Intermediate, optIntermediateProto},
+		{&ps.Output, optOutputProto},
+	} {
+		name := s.name
+		if name == "" {
+			name = def
+		}
+		p, err := ProtocolByName(name)
+		if err != nil {
+			return nil, err
+		}
+		*s.p = p
+	}
+
+	return ps, nil
+}
+
+// the protocol for stages without one of their own: the first one given, or JSON
+func defaultProtoName() string {
+	for _, name := range []string{optInputProto, optIntermediateProto, optOutputProto} {
+		if name != "" {
+			return name
+		}
+	}
+	return "json"
+}
+
+// the name of the protocol JobProtocols uses for the intermediate stage
+func intermediateProtoName() string {
+	if optIntermediateProto != "" {
+		return optIntermediateProto
+	}
+	return defaultProtoName()
+}
+
+// JSONProtocol parse input/output values as JSON strings.  Nil pointers are
+// written as null, and read back as nil, so they stay distinct from empty strings.
 type JSONProtocol struct {
 	// empty -- just a type
 }
@@ -52,16 +165,30 @@ func (p *JSONProtocol) UnmarshalKVs(key string, values []string, k interface{},
 	vsPtrValue.Elem().Set(v)
 }
 
-// MarshalKV implements the StreamProtocol interface
+// MarshalKV implements the StreamProtocol interface.  encoding/json writes map
+// keys in sorted order, so equal map-valued keys always marshal to the same
+// string and are grouped together by the reducer.
 func (p *JSONProtocol) MarshalKV(key interface{}, value interface{}) *KeyValue {
-	k, _ := json.Marshal(key)
-	v, _ := json.Marshal(value)
-	return &KeyValue{string(k), string(v)}
+	return &KeyValue{string(marshalJSON(key)), string(marshalJSON(value))}
 }
 
-// TSVProtocol outputs keys as tab-separated lines
+// MarshalKey implements the KeyMarshaler interface
+func (p *JSONProtocol) MarshalKey(key interface{}) string {
+	return string(marshalJSON(key))
+}
+
+// TSVProtocol outputs keys as tab-separated lines.  Pointer fields are
+// written as the value they point to, or TSVNull if they are nil.
 type TSVProtocol struct {
-	// empty -- just a type
+	// Separator between value fields.  If empty, a tab is used.
+	Separator string
+}
+
+func (p *TSVProtocol) separator() string {
+	if p.Separator == "" {
+		return "\t"
+	}
+	return p.Separator
 }
 
 // MarshalKV implements the StreamProtocol interface
@@ -92,15 +219,22 @@ func (p *TSVProtocol) MarshalKV(key interface{}, value interface{}) *KeyValue {
 		}
 	}
 
-	vals := strings.Join(vs, "\t")
+	vals := strings.Join(vs, p.separator())
 
 	return &KeyValue{k, vals}
 }
 
+// MarshalKey implements the KeyMarshaler interface
+func (p *TSVProtocol) MarshalKey(key interface{}) string {
+	return primitiveToString(reflect.ValueOf(key))
+}
+
 // UnmarshalKVs implements the StreamProtocol interface
 func (p *TSVProtocol) UnmarshalKVs(key string, values []string, k interface{}, vs interface{}) {
 
-	fmt.Sscan(key, &k)
+	if kv := reflect.ValueOf(k); kv.Kind() == reflect.Ptr && !kv.IsNil() {
+		scanField(key, kv.Elem())
+	}
 
 	vsPtrValue := reflect.ValueOf(vs)
 	vsType := reflect.TypeOf(vs).Elem()
@@ -109,34 +243,71 @@ func (p *TSVProtocol) UnmarshalKVs(key string, values []string, k interface{}, v
 	v := reflect.MakeSlice(vsType, len(values), len(values))
 
 	for vi, s := range values {
-		vs := strings.Split(s, "\t")
+		vs := strings.Split(s, p.separator())
 
 		// create our new element
 		e := v.Index(vi)
 
 		// figure out what kind we need to unpack our data into
 		if vType.Kind() == reflect.Struct {
-			for i := 0; i < vType.NumField(); i++ {
-				_, err := fmt.Sscan(vs[i], e.Field(i).Addr().Interface())
+			for i := 0; i < vType.NumField() && i < len(vs); i++ {
+				err := scanField(vs[i], e.Field(i))
 				if err != nil {
 					continue // skip
 				}
 			}
 		} else if vType.Kind() == reflect.Array {
-			for i := 0; i < vType.Len(); i++ {
-				_, err := fmt.Sscan(vs[i], e.Index(i).Addr().Interface())
+			for i := 0; i < vType.Len() && i < len(vs); i++ {
+				err := scanField(vs[i], e.Index(i))
 				if err != nil {
 					continue // skip
 				}
 			}
 		} else if isPrimitive(vType.Kind()) {
-			fmt.Sscan(vs[0], e.Addr().Interface())
+			scanField(vs[0], e)
 		}
 	}
 
 	vsPtrValue.Elem().Set(v)
 }
 
+// TSVNull is the TSV field for a nil pointer, as used by Hive and MySQL.  It
+// keeps a null distinct from an empty string.
+const TSVNull = `\N`
+
+// scanField parses a single TSV field into v.  Fields missing from the end of
+// a short line are never scanned, and so are left as zero values.  An empty
+// field is an explicit empty string, or the zero value for non-string types.
+// Pointers are set to nil for TSVNull, and otherwise to a new value.
+func scanField(s string, v reflect.Value) error {
+
+	if v.Kind() == reflect.Ptr {
+		if s == TSVNull {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		p := reflect.New(v.Type().Elem())
+		if err := scanField(s, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	if v.Kind() == reflect.String {
+		v.SetString(s)
+		return nil
+	}
+
+	if s == "" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	_, err := fmt.Sscan(s, v.Addr().Interface())
+	return err
+}
+
 func isPrimitive(k reflect.Kind) bool {
 
 	switch k {
@@ -168,10 +339,19 @@ func primitiveToString(v reflect.Value) string {
 		return strconv.FormatUint(v.Uint(), 10)
 
 	case reflect.Float32, reflect.Float64:
+		if isNonFinite(v.Float()) {
+			return nonFiniteString(v.Float())
+		}
 		return strconv.FormatFloat(v.Float(), 'g', 5, 64)
 	case reflect.String:
 		return v.String()
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return TSVNull
+		}
+		return primitiveToString(v.Elem())
 	}
 
-	return "(unknown type " + string(v.Kind()) + ")"
+	return "(unknown type " + v.Kind().String() + ")"
 }
@@ -0,0 +1,70 @@
+package dmrgo
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type benchRecord struct {
+	Name  string
+	Count int
+	Score float64
+}
+
+// benchRoundTrip marshals key and value with the protocol, writes them as a
+// line, reads the line back, and unmarshals it, as a record goes from a mapper
+// to a reducer.  The first round trip must give back what went in.
+func benchRoundTrip[K, V any](b *testing.B, name string, key K, value V) {
+
+	p, err := ProtocolByName(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	r := bufio.NewReader(&buf)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		w.Reset(&buf)
+
+		kv := p.MarshalKV(key, value)
+		WriteKeyValue(w, *kv)
+		w.Flush()
+		b.SetBytes(int64(buf.Len()))
+
+		r.Reset(&buf)
+		rkv, err := ReadKeyValue(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var k K
+		var vs []V
+		p.UnmarshalKVs(rkv.Key, []string{rkv.Value}, &k, &vs)
+
+		if i == 0 && (!reflect.DeepEqual(k, key) || len(vs) != 1 || !reflect.DeepEqual(vs[0], value)) {
+			b.Fatalf("%s round trip: got %v %v, want %v %v", name, k, vs, key, value)
+		}
+	}
+}
+
+func BenchmarkProtocols(b *testing.B) {
+
+	record := benchRecord{"gopher", 42, 0.5}
+
+	for _, name := range []string{"json", "tsv", "sortable-json", "typedbytes"} {
+		b.Run(name+"/struct", func(b *testing.B) { benchRoundTrip(b, name, "key", record) })
+		b.Run(name+"/primitive", func(b *testing.B) { benchRoundTrip(b, name, "key", 42) })
+	}
+
+	// query strings only encode structs and maps, so the primitive-valued
+	// record is a map of one string
+	b.Run("querystring/struct", func(b *testing.B) { benchRoundTrip(b, "querystring", "key", record) })
+	b.Run("querystring/primitive", func(b *testing.B) { benchRoundTrip(b, "querystring", "key", map[string]string{"n": "42"}) })
+}
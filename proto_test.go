@@ -0,0 +1,186 @@
+package dmrgo
+
+import (
+	"strings"
+	"testing"
+)
+
+type tsvRecord struct {
+	Name  string
+	Count int
+	Score float64
+	Ok    bool
+}
+
+func TestTSVSeparatorRoundTrip(t *testing.T) {
+
+	p := &TSVProtocol{Separator: "|"}
+
+	want := tsvRecord{"gopher", 42, 0.5, true}
+
+	kv := p.MarshalKV("key", want)
+	if kv.Value != "gopher|42|0.5|1" {
+		t.Errorf("MarshalKV value=%q, want %q", kv.Value, "gopher|42|0.5|1")
+	}
+
+	var k string
+	var vs []tsvRecord
+	p.UnmarshalKVs(kv.Key, []string{kv.Value}, &k, &vs)
+
+	if k != "key" || len(vs) != 1 || vs[0] != want {
+		t.Errorf("round trip gave %q %v, want %q %v", k, vs, "key", want)
+	}
+}
+
+type tsvWide struct {
+	A string
+	B *string
+	C int
+	D string
+}
+
+func TestTSVShortLine(t *testing.T) {
+
+	p := new(TSVProtocol)
+
+	var k string
+	var vs []tsvWide
+	p.UnmarshalKVs("key", []string{"x", "x\t\t7", "x\t\\N\t7\t"}, &k, &vs)
+
+	if len(vs) != 3 {
+		t.Fatalf("got %d values, want 3", len(vs))
+	}
+
+	// fields missing from a short line are left as zero values
+	if vs[0].A != "x" || vs[0].B != nil || vs[0].C != 0 || vs[0].D != "" {
+		t.Errorf("short line gave %+v, want just A set", vs[0])
+	}
+
+	// an empty field is an empty string, distinct from a missing one
+	if vs[1].B == nil || *vs[1].B != "" || vs[1].C != 7 {
+		t.Errorf("line with an empty field gave %+v, want B pointing at \"\" and C=7", vs[1])
+	}
+
+	if vs[2].B != nil || vs[2].C != 7 || vs[2].D != "" {
+		t.Errorf("line with a null field gave %+v, want B nil and C=7", vs[2])
+	}
+}
+
+func TestTSVNilPointerRoundTrip(t *testing.T) {
+
+	p := new(TSVProtocol)
+
+	empty, name := "", "gopher"
+	in := []tsvWide{{"a", nil, 1, "d"}, {"a", &empty, 2, ""}, {"a", &name, 3, "d"}}
+
+	var values []string
+	for _, v := range in {
+		values = append(values, p.MarshalKV("key", v).Value)
+	}
+
+	if want := "a\t\\N\t1\td"; values[0] != want {
+		t.Errorf("nil pointer marshaled as %q, want %q", values[0], want)
+	}
+
+	var k string
+	var vs []tsvWide
+	p.UnmarshalKVs("key", values, &k, &vs)
+
+	if len(vs) != len(in) {
+		t.Fatalf("got %d values, want %d", len(vs), len(in))
+	}
+
+	for i := range in {
+		got, want := vs[i], in[i]
+		if got.A != want.A || got.C != want.C || got.D != want.D || (got.B == nil) != (want.B == nil) || (got.B != nil && *got.B != *want.B) {
+			t.Errorf("%q round tripped to %+v, want %+v", values[i], got, want)
+		}
+	}
+}
+
+func TestProtocolByName(t *testing.T) {
+
+	for _, name := range []string{"json", "tsv", "sortable-json", "querystring", "typedbytes"} {
+		if _, ok := protocols[name]; !ok {
+			t.Errorf("built-in protocol %q isn't registered", name)
+		}
+	}
+
+	protocolsMu.RLock()
+	var names []string
+	for name := range protocols {
+		names = append(names, name)
+	}
+	protocolsMu.RUnlock()
+
+	for _, name := range names {
+		p, err := ProtocolByName(name)
+		if err != nil || p == nil {
+			t.Errorf("ProtocolByName(%q)=%v, %v", name, p, err)
+		}
+	}
+
+	if _, err := ProtocolByName("no-such-protocol"); err == nil || !strings.Contains(err.Error(), `"no-such-protocol"`) {
+		t.Errorf("ProtocolByName of an unknown name gave error %v, want one naming it", err)
+	}
+
+	setFlag(t, "intermediate-proto", "no-such-protocol")
+	if _, err := JobProtocols(); err == nil {
+		t.Errorf("JobProtocols with an unknown -intermediate-proto gave no error")
+	}
+}
+
+// upperProtocol is JSON, but with its keys upper-cased
+type upperProtocol struct {
+	JSONProtocol
+}
+
+func (p *upperProtocol) MarshalKV(key interface{}, value interface{}) *KeyValue {
+	kv := p.JSONProtocol.MarshalKV(key, value)
+	kv.Key = strings.ToUpper(kv.Key)
+	return kv
+}
+
+func TestRegisterProtocol(t *testing.T) {
+
+	RegisterProtocol("test-upper", func() StreamProtocol { return new(upperProtocol) })
+	defer func() {
+		protocolsMu.Lock()
+		delete(protocols, "test-upper")
+		protocolsMu.Unlock()
+	}()
+
+	p, err := ProtocolByName("test-upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kv := p.MarshalKV("key", 1); kv.Key != `"KEY"` || kv.Value != "1" {
+		t.Errorf("registered protocol marshaled %q %q, want %q %q", kv.Key, kv.Value, `"KEY"`, "1")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a name twice didn't panic")
+		}
+	}()
+
+	RegisterProtocol("test-upper", func() StreamProtocol { return new(JSONProtocol) })
+}
+
+func TestJSONMapsMarshalDeterministically(t *testing.T) {
+
+	m := make(map[string]interface{})
+	for i := 0; i < 50; i++ {
+		m[string(rune('a'+i%26))+strings.Repeat("x", i/26)] = map[string]int{"z": i, "y": -i, "x": 0}
+	}
+
+	for _, p := range []StreamProtocol{new(JSONProtocol), new(SortableJSONProtocol)} {
+		first := p.MarshalKV(m, m)
+		for i := 0; i < 20; i++ {
+			if kv := p.MarshalKV(m, m); *kv != *first {
+				t.Fatalf("%T: marshaling the same map twice gave\n%q\n%q", p, *first, *kv)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package dmrgo
+
+// Recording the intermediate protocol in the intermediate data
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"errors"
+	"fmt"
+)
+
+// write the intermediate protocol name at the start of each map output, and check it when reducing
+var optProtoHeader bool
+
+// the key of the header record.  The leading NUL sorts it before any other key,
+// so the reducer sees the headers first.
+const protoHeaderKey = "\x00dmrgo-proto"
+
+// writeProtoHeader emits the header record, if -proto-header was given.  It's
+// only written to the standalone map/reduce's intermediate files: with Hadoop
+// streaming, the one record would only reach one of the reducers.
+func writeProtoHeader(e Emitter) {
+	if optProtoHeader {
+		e.Emit(protoHeaderKey, intermediateProtoName())
+	}
+}
+
+var errNoProtoHeader = errors.New("reducer input has no protocol header (were the mappers run with -proto-header?)")
+
+// protoHeaderReader removes the header records from the reducer input, and
+// checks they name the intermediate protocol the reducer expects.  A mismatch,
+// or input with no header, would silently corrupt the output, so ends the input
+// with an error.
+type protoHeaderReader struct {
+	r    RecordReader
+	seen bool
+}
+
+// checkProtoHeader wraps r in a protoHeaderReader, if -proto-header was given
+func checkProtoHeader(r RecordReader) RecordReader {
+	if !optProtoHeader {
+		return r
+	}
+	return &protoHeaderReader{r: r}
+}
+
+func (p *protoHeaderReader) ReadRecord() (*KeyValue, error) {
+
+	for {
+		kv, err := p.r.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		if kv.Key != protoHeaderKey {
+			if !p.seen {
+				return nil, errNoProtoHeader
+			}
+			return kv, nil
+		}
+
+		if want := intermediateProtoName(); kv.Value != want {
+			return nil, fmt.Errorf("reducer input was written with protocol %q, but the reducer uses %q", kv.Value, want)
+		}
+
+		p.seen = true
+	}
+}
@@ -0,0 +1,56 @@
+package dmrgo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProtoHeader(t *testing.T) {
+
+	quietStderr(t)
+
+	// without -proto-header, the reader is left alone
+	r := &sliceRecordReader{}
+	if checkProtoHeader(r) != RecordReader(r) {
+		t.Errorf("checkProtoHeader wrapped the reader without -proto-header")
+	}
+
+	setFlag(t, "proto-header", "true")
+	setFlag(t, "intermediate-proto", "tsv")
+
+	var s SliceEmitter
+	writeProtoHeader(&s)
+	header := s.KeyValues[0]
+
+	tests := []struct {
+		kvs  []KeyValue
+		want []KeyValue
+		err  string
+	}{
+		{[]KeyValue{header, header, {"a", "1"}}, []KeyValue{{"a", "1"}}, ""},
+		{[]KeyValue{{protoHeaderKey, "json"}, {"a", "1"}}, nil, `written with protocol "json", but the reducer uses "tsv"`},
+		{[]KeyValue{{"a", "1"}}, nil, "no protocol header"},
+	}
+
+	for _, tt := range tests {
+		var e SliceEmitter
+		err := reduceRecords(joinJob{}, checkProtoHeader(&sliceRecordReader{tt.kvs}), &e)
+
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("reducing %q gave error %v, want %q", tt.kvs, err, tt.err)
+		}
+		if !reflect.DeepEqual(e.KeyValues, tt.want) {
+			t.Errorf("reducing %q gave %v, want %v", tt.kvs, e.KeyValues, tt.want)
+		}
+	}
+
+	_, lines, err := runLocal(t, joinJob{}, []string{"b a\n", "a\n"}, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a\t1,1", "b\t1"}; !reflect.DeepEqual(sortedLines(lines), want) {
+		t.Errorf("with -proto-header, reduced %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,160 @@
+package dmrgo
+
+// Approximate quantiles with a t-digest
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"math"
+	"sort"
+)
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// Quantile estimates quantiles of a stream of values using a merging t-digest
+// (Dunning & Ertl).  Memory use is bounded by the compression, regardless of
+// how many values are added; estimates are most accurate near the extremes.
+// Quantile is not safe for concurrent use.
+type Quantile struct {
+	compression float64
+	centroids   []centroid
+	buf         []float64
+	total       float64
+	min, max    float64
+}
+
+// NewQuantile returns a Quantile with the given compression.  Higher
+// compression is more accurate but uses more memory; 100 is a good default.
+func NewQuantile(compression float64) *Quantile {
+	if compression < 10 {
+		compression = 10
+	}
+	return &Quantile{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// Add adds a value to the sketch
+func (q *Quantile) Add(x float64) {
+
+	if math.IsNaN(x) {
+		return
+	}
+
+	q.buf = append(q.buf, x)
+	q.total++
+
+	if x < q.min {
+		q.min = x
+	}
+	if x > q.max {
+		q.max = x
+	}
+
+	if len(q.buf) >= int(5*q.compression) {
+		q.compress()
+	}
+}
+
+// Count returns the number of values added
+func (q *Quantile) Count() int {
+	return int(q.total)
+}
+
+// the t-digest k1 scale function, and its inverse
+func (q *Quantile) k(quantile float64) float64 {
+	return q.compression / (2 * math.Pi) * math.Asin(2*quantile-1)
+}
+
+func (q *Quantile) kInv(k float64) float64 {
+	return (math.Sin(k*2*math.Pi/q.compression) + 1) / 2
+}
+
+// merge the buffered values into the centroids
+func (q *Quantile) compress() {
+
+	if len(q.buf) == 0 {
+		return
+	}
+
+	items := make([]centroid, 0, len(q.centroids)+len(q.buf))
+	items = append(items, q.centroids...)
+	for _, x := range q.buf {
+		items = append(items, centroid{x, 1})
+	}
+	q.buf = q.buf[:0]
+
+	sort.Slice(items, func(i, j int) bool { return items[i].mean < items[j].mean })
+
+	merged := make([]centroid, 0, len(q.centroids))
+
+	cur := items[0]
+	var soFar float64
+	limit := q.total * q.kInv(q.k(0)+1)
+
+	for _, next := range items[1:] {
+		if soFar+cur.weight+next.weight <= limit {
+			// merge next into the current centroid
+			cur.weight += next.weight
+			cur.mean += (next.mean - cur.mean) * next.weight / cur.weight
+			continue
+		}
+
+		merged = append(merged, cur)
+		soFar += cur.weight
+		limit = q.total * q.kInv(q.k(soFar/q.total)+1)
+		cur = next
+	}
+
+	q.centroids = append(merged, cur)
+}
+
+// Quantile returns an estimate of the value at quantile p, which should be in [0, 1].
+// With no values added, it returns NaN.
+func (q *Quantile) Quantile(p float64) float64 {
+
+	q.compress()
+
+	if len(q.centroids) == 0 {
+		return math.NaN()
+	}
+
+	if p <= 0 {
+		return q.min
+	}
+	if p >= 1 {
+		return q.max
+	}
+
+	if len(q.centroids) == 1 {
+		return q.centroids[0].mean
+	}
+
+	target := p * q.total
+
+	// before the center of the first centroid: interpolate from the minimum
+	first := q.centroids[0]
+	if target < first.weight/2 {
+		return q.min + (first.mean-q.min)*target/(first.weight/2)
+	}
+
+	// between the centers of two centroids
+	cum := first.weight / 2
+	for i := 0; i < len(q.centroids)-1; i++ {
+		c, next := q.centroids[i], q.centroids[i+1]
+		step := (c.weight + next.weight) / 2
+		if target < cum+step {
+			return c.mean + (next.mean-c.mean)*(target-cum)/step
+		}
+		cum += step
+	}
+
+	// after the center of the last centroid: interpolate to the maximum
+	last := q.centroids[len(q.centroids)-1]
+	return last.mean + (q.max-last.mean)*(target-cum)/(last.weight/2)
+}
@@ -0,0 +1,47 @@
+package dmrgo
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQuantileAccuracy(t *testing.T) {
+
+	rng := rand.New(rand.NewSource(1))
+
+	const n = 100000
+
+	q := NewQuantile(100)
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = rng.NormFloat64()*10 + 50
+		q.Add(values[i])
+	}
+	sort.Float64s(values)
+
+	if q.Count() != n {
+		t.Errorf("Count()=%d, want %d", q.Count(), n)
+	}
+
+	// the estimate's rank must be near the quantile asked for: the t-digest is
+	// more accurate towards the tails
+	for _, tt := range []struct {
+		p, tolerance float64
+	}{
+		{0.5, 0.01},
+		{0.95, 0.005},
+		{0.99, 0.002},
+	} {
+		est := q.Quantile(tt.p)
+		rank := float64(sort.SearchFloat64s(values, est)) / n
+		if math.Abs(rank-tt.p) > tt.tolerance {
+			t.Errorf("Quantile(%v)=%v, which is at quantile %v, outside ±%v", tt.p, est, rank, tt.tolerance)
+		}
+	}
+
+	if !math.IsNaN(NewQuantile(100).Quantile(0.5)) {
+		t.Errorf("Quantile of no values isn't NaN")
+	}
+}
@@ -0,0 +1,131 @@
+package dmrgo
+
+// A protocol for URL query-string encoded values
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+)
+
+// QueryStringProtocol parses values encoded as URL query strings (a=1&b=2).
+// Values may be a map[string]string, a url.Values (or map[string][]string), or
+// a struct.  Struct fields are named by their `qs` tag, or by the field name,
+// and a slice field collects all the values for a repeated parameter.  Keys are
+// plain strings, as with TSVProtocol.
+type QueryStringProtocol struct {
+	// empty -- just a type
+}
+
+// UnmarshalKVs implements the StreamProtocol interface
+func (p *QueryStringProtocol) UnmarshalKVs(key string, values []string, k interface{}, vs interface{}) {
+
+	if ks, ok := k.(*string); ok {
+		*ks = key
+	} else {
+		fmt.Sscan(key, k)
+	}
+
+	vsPtrValue := reflect.ValueOf(vs)
+	vsType := reflect.TypeOf(vs).Elem()
+	vType := vsType.Elem()
+
+	v := reflect.MakeSlice(vsType, len(values), len(values))
+
+	for vi, s := range values {
+
+		q, err := url.ParseQuery(s)
+		if err != nil {
+			// skip, for now
+			continue
+		}
+
+		e := v.Index(vi)
+
+		switch {
+		case vType.Kind() == reflect.Map && vType.Elem().Kind() == reflect.String:
+			m := reflect.MakeMap(vType)
+			for name := range q {
+				m.SetMapIndex(reflect.ValueOf(name), reflect.ValueOf(q.Get(name)))
+			}
+			e.Set(m)
+
+		case vType.Kind() == reflect.Map && vType.Elem().Kind() == reflect.Slice:
+			e.Set(reflect.ValueOf(q).Convert(vType))
+
+		case vType.Kind() == reflect.Struct:
+			for i := 0; i < vType.NumField(); i++ {
+				qv, ok := q[queryFieldName(vType.Field(i))]
+				if !ok {
+					continue
+				}
+				field := e.Field(i)
+				if field.Kind() == reflect.Slice {
+					field.Set(reflect.MakeSlice(field.Type(), len(qv), len(qv)))
+					for j, s := range qv {
+						scanField(s, field.Index(j))
+					}
+				} else {
+					scanField(qv[0], field)
+				}
+			}
+		}
+	}
+
+	vsPtrValue.Elem().Set(v)
+}
+
+// MarshalKV implements the StreamProtocol interface
+func (p *QueryStringProtocol) MarshalKV(key interface{}, value interface{}) *KeyValue {
+
+	k := primitiveToString(reflect.ValueOf(key))
+
+	q := url.Values{}
+
+	vVal := reflect.ValueOf(value)
+
+	switch vVal.Kind() {
+	case reflect.Map:
+		for _, name := range vVal.MapKeys() {
+			mv := vVal.MapIndex(name)
+			if mv.Kind() == reflect.Slice {
+				for j := 0; j < mv.Len(); j++ {
+					q.Add(name.String(), primitiveToString(mv.Index(j)))
+				}
+			} else {
+				q.Set(name.String(), primitiveToString(mv))
+			}
+		}
+
+	case reflect.Struct:
+		vType := vVal.Type()
+		for i := 0; i < vType.NumField(); i++ {
+			name := queryFieldName(vType.Field(i))
+			field := vVal.Field(i)
+			if field.Kind() == reflect.Slice {
+				for j := 0; j < field.Len(); j++ {
+					q.Add(name, primitiveToString(field.Index(j)))
+				}
+			} else {
+				q.Set(name, primitiveToString(field))
+			}
+		}
+	}
+
+	// Encode sorts by parameter name, so equal values always encode the same
+	return &KeyValue{k, q.Encode()}
+}
+
+// MarshalKey implements the KeyMarshaler interface
+func (p *QueryStringProtocol) MarshalKey(key interface{}) string {
+	return primitiveToString(reflect.ValueOf(key))
+}
+
+func queryFieldName(f reflect.StructField) string {
+	if name := f.Tag.Get("qs"); name != "" {
+		return name
+	}
+	return f.Name
+}
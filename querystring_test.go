@@ -0,0 +1,45 @@
+package dmrgo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryStringRoundTrip(t *testing.T) {
+
+	type hit struct {
+		Path  string   `qs:"path"`
+		Query string   `qs:"q"`
+		Count int      `qs:"n"`
+		Tags  []string `qs:"tag"`
+	}
+
+	p := new(QueryStringProtocol)
+
+	// characters which must be encoded: separators, spaces, '%', '+', '=', and non-ASCII
+	want := hit{"/a b/c?d", "x=1&y=2 + 100% über", 3, []string{"a&b", "c=d"}}
+
+	kv := p.MarshalKV("key", want)
+
+	if strings.ContainsAny(kv.Value, " \t\n") || strings.Count(kv.Value, "&") != 4 {
+		t.Errorf("value %q isn't a properly escaped query string", kv.Value)
+	}
+
+	var k string
+	var vs []hit
+	p.UnmarshalKVs(kv.Key, []string{kv.Value}, &k, &vs)
+
+	if k != "key" || len(vs) != 1 || !reflect.DeepEqual(vs[0], want) {
+		t.Errorf("round trip gave %q %+v, want %q %+v", k, vs, "key", want)
+	}
+
+	m := map[string]string{"a b": "c&d", "e": "f=g"}
+
+	var ms []map[string]string
+	p.UnmarshalKVs("key", []string{p.MarshalKV("key", m).Value}, &k, &ms)
+
+	if len(ms) != 1 || !reflect.DeepEqual(ms[0], m) {
+		t.Errorf("map round trip gave %v, want %v", ms, m)
+	}
+}
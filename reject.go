@@ -0,0 +1,102 @@
+package dmrgo
+
+// A separate output for records rejected by the job
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// where to write rejected records, and in what format (json/tsv)
+var optRejectOutput string
+var optRejectFormat string
+
+// ReasonCode is a machine-readable reason for rejecting a record
+type ReasonCode string
+
+// RejectEmitter writes rejected records, each tagged with the reason it was
+// rejected, to an output of their own.  In "json" format each line is an object
+// with "key", "value" and "code" fields; in "tsv" format each line is the code,
+// key and value separated by tabs, each escaped as -escape escapes the line
+// format.  Rejects are also counted by reason code.
+// RejectEmitter is safe for concurrent use.
+type RejectEmitter struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	format string
+}
+
+// NewRejectEmitter returns a RejectEmitter writing to w in the given format
+func NewRejectEmitter(w io.Writer, format string) *RejectEmitter {
+	return &RejectEmitter{w: bufio.NewWriter(w), format: format}
+}
+
+// Reject writes the key/value pair to the reject output, with the reason code
+func (r *RejectEmitter) Reject(key string, value string, code ReasonCode) {
+
+	IncrCounter("dmrgo rejects", counterName(string(code)), 1)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.format == "tsv" {
+		e := lineEscaper()
+		fmt.Fprintf(r.w, "%s\t%s\t%s\n", e.Escape(string(code)), e.Escape(key), e.Escape(value))
+		return
+	}
+
+	b, _ := json.Marshal(struct {
+		Key   string     `json:"key"`
+		Value string     `json:"value"`
+		Code  ReasonCode `json:"code"`
+	}{key, value, code})
+	r.w.Write(b)
+	r.w.WriteByte('\n')
+}
+
+// Emit implements the Emitter interface, rejecting the pair with an empty reason code
+func (r *RejectEmitter) Emit(key string, value string) {
+	r.Reject(key, value, "")
+}
+
+// Flush implements the Emitter interface
+func (r *RejectEmitter) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Flush()
+}
+
+var rejectsOnce sync.Once
+var rejects *RejectEmitter
+
+// Rejects returns the RejectEmitter for the -reject-output file, for use from
+// Map or Reduce.  If no file was given, rejects are counted but discarded.
+func Rejects() *RejectEmitter {
+	rejectsOnce.Do(func() {
+		var w io.Writer = ioutil.Discard
+		if optRejectOutput != "" {
+			f, err := os.Create(optRejectOutput)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "err creating reject output: ", err)
+			} else {
+				w = f
+			}
+		}
+		rejects = NewRejectEmitter(w, optRejectFormat)
+	})
+	return rejects
+}
+
+// flushRejects flushes the reject output, if anything was rejected
+func flushRejects() {
+	if rejects != nil {
+		rejects.Flush()
+	}
+}
@@ -0,0 +1,127 @@
+package dmrgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRejectEmitter(t *testing.T) {
+
+	quietStderr(t)
+
+	resetCounters()
+
+	var buf bytes.Buffer
+	r := NewRejectEmitter(&buf, "json")
+	r.Reject("k\t1", "bad \"value\"", "too-long")
+	r.Reject("k2", "v2", "too-long")
+	r.Emit("k3", "v3")
+	r.Flush()
+
+	type reject struct {
+		Key   string     `json:"key"`
+		Value string     `json:"value"`
+		Code  ReasonCode `json:"code"`
+	}
+
+	want := []reject{{"k\t1", "bad \"value\"", "too-long"}, {"k2", "v2", "too-long"}, {"k3", "v3", ""}}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("json rejects: %q, want %d lines", buf.String(), len(want))
+	}
+	for i, line := range lines {
+		var got reject
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Errorf("json reject %q: %v", line, err)
+		} else if got != want[i] {
+			t.Errorf("json reject %q decoded as %v, want %v", line, got, want[i])
+		}
+	}
+
+	if got := counterTotals()["dmrgo rejects"]["too-long"]; got != 2 {
+		t.Errorf("too-long rejects counted %d, want 2", got)
+	}
+
+	buf.Reset()
+	r = NewRejectEmitter(&buf, "tsv")
+	r.Reject("k", "v", "empty")
+	r.Flush()
+
+	if got := buf.String(); got != "empty\tk\tv\n" {
+		t.Errorf("tsv reject %q, want %q", got, "empty\tk\tv\n")
+	}
+
+	// tabs and newlines are escaped as in the line format
+	setFlag(t, "escape", "backslash")
+
+	buf.Reset()
+	r = NewRejectEmitter(&buf, "tsv")
+	r.Reject("k\t1", "two\nlines", "bad")
+	r.Flush()
+
+	if got, want := buf.String(), `bad	k\t1	two\nlines`+"\n"; got != want {
+		t.Errorf("tsv reject %q, want %q", got, want)
+	}
+}
+
+// rejectJob counts words, rejecting those starting with "x"
+type rejectJob struct {
+	wordJob
+}
+
+func (rejectJob) Map(key string, value string, emitter Emitter) {
+	for _, w := range strings.Fields(value) {
+		if strings.HasPrefix(w, "x") {
+			Rejects().Reject(w, value, "x-word")
+			continue
+		}
+		emitter.Emit(w, "1")
+	}
+}
+
+func TestRejectsFlushedAfterRun(t *testing.T) {
+
+	// each test gets its own reject output
+	resetRejects := func() {
+		rejectsOnce = sync.Once{}
+		rejects = nil
+	}
+	resetRejects()
+	defer resetRejects()
+
+	output := filepath.Join(t.TempDir(), "rejects")
+	setFlag(t, "reject-output", output)
+	setFlag(t, "reject-format", "tsv")
+
+	_, lines, err := runLocal(t, rejectJob{}, []string{"a xb c\n"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a\t1", "c\t1"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("reduced %q, want %q", lines, want)
+	}
+
+	b, err := ioutil.ReadFile(output)
+	if got, want := string(b), "x-word\txb\ta xb c\n"; err != nil || got != want {
+		t.Errorf("after RunLocalFiles, the reject output has %q, %v, want %q", got, err, want)
+	}
+
+	var out bytes.Buffer
+	if err := RunReaders(rejectJob{}, []io.Reader{strings.NewReader("xy\n")}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = ioutil.ReadFile(output)
+	if got, want := string(b), "x-word\txb\ta xb c\nx-word\txy\txy\n"; err != nil || got != want {
+		t.Errorf("after RunReaders, the reject output has %q, %v, want %q", got, err, want)
+	}
+}
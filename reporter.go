@@ -7,8 +7,13 @@ package dmrgo
 import (
 	"fmt"
 	"os"
+	"sync"
 )
 
+// the totals of the counters incremented by this process, by group and name
+var countersMu sync.Mutex
+var counters = make(map[string]map[string]int64)
+
 // Statusln updates the Hadoop job status.  The arguments are passed to fmt.Sprintln
 func Statusln(a ...interface{}) {
 	s := fmt.Sprintln(a...)
@@ -24,4 +29,32 @@ func Statusf(format string, a ...interface{}) {
 // IncrCounter updates the given group/counter by 'amount'
 func IncrCounter(group, counter string, amount int) {
 	fmt.Fprintf(os.Stderr, "reporter:counter:%s,%s,%d\n", group, counter, amount)
+
+	countersMu.Lock()
+	if counters[group] == nil {
+		counters[group] = make(map[string]int64)
+	}
+	counters[group][counter] += int64(amount)
+	countersMu.Unlock()
+}
+
+// counterTotals returns a copy of the counter totals
+func counterTotals() map[string]map[string]int64 {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	totals := make(map[string]map[string]int64, len(counters))
+	for group, names := range counters {
+		totals[group] = make(map[string]int64, len(names))
+		for name, n := range names {
+			totals[group][name] = n
+		}
+	}
+	return totals
+}
+
+// resetCounters sets all the counter totals back to zero
+func resetCounters() {
+	countersMu.Lock()
+	counters = make(map[string]map[string]int64)
+	countersMu.Unlock()
 }
@@ -0,0 +1,169 @@
+package dmrgo
+
+// Running standalone map/reduce jobs from Go code
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// RunResult describes a standalone map/reduce run.  The embedded Manifest has
+// the per-partition record and byte counts, and the phase timings; for a
+// map-only job, Files is empty.  Phases has when the map and reduce phases
+// ran.  Outputs are the paths of all the output files, in order.  Counters are
+// the totals of the counters incremented during the run, by group and name.
+// Errors are the errors of any inputs or partitions which failed.
+type RunResult struct {
+	Manifest
+	Phases   PhaseTimes
+	Outputs  []string
+	Counters map[string]map[string]int64
+	Errors   []error
+}
+
+// RunOptions are the settings for RunLocalFiles.  Fields left as zero keep the
+// value from the command line flags, or the flag's default.
+type RunOptions struct {
+	Partitions int
+	Reducers   int
+	Mappers    int
+
+	// run a map-only job, with no reducers
+	MapOnly bool
+}
+
+// RunLocalFiles runs the job with the standalone map/reduce, as -mapreduce
+// does, over the input files, or stdin if there are none.  The output files
+// are left in the current directory.  If any input or partition failed, the
+// first error is returned along with the result.  The options, like the
+// command line flags, are global, so only one job may be run at a time.
+func RunLocalFiles(mrjob MapReduceJob, inputs []string, opts *RunOptions) (*RunResult, error) {
+
+	defaultFlags()
+
+	if opts != nil {
+		if opts.Partitions > 0 {
+			optNumPartitions = opts.Partitions
+		}
+		if opts.Reducers > 0 {
+			optNumReducers = opts.Reducers
+		}
+		if opts.Mappers > 0 {
+			optNumMappers = opts.Mappers
+		}
+		if opts.MapOnly {
+			optNumReducers = 0
+		}
+	}
+
+	res, err := runMapReduce(mrjob, inputs)
+	if err != nil {
+		if ff, ok := err.(*failedFastError); ok {
+			return nil, ff.err
+		}
+		return nil, err
+	}
+
+	if len(res.Errors) > 0 {
+		return res, res.Errors[0]
+	}
+
+	return res, nil
+}
+
+// RunReaders runs the job over the inputs entirely in memory, writing the
+// reducer output to output.  Up to -mappers inputs are read at once (by
+// default, twice the number of CPUs).  All the map output is held in memory
+// to be sorted, so this is for inputs which fit in memory comfortably; the
+// output is a single partition.  With -on-bad-record fail, a bad record stops
+// the run, and its error is returned.
+func RunReaders(mrjob MapReduceJob, inputs []io.Reader, output io.Writer) error {
+
+	defaultFlags()
+
+	run := newRunState()
+	setCurrentRun(run)
+	defer setCurrentRun(nil)
+
+	defer flushRejects()
+
+	mappers := optNumMappers
+	if mappers <= 0 {
+		mappers = 2 * runtime.NumCPU()
+	}
+	if mappers > len(inputs) {
+		mappers = len(inputs)
+	}
+
+	// the map output for each input, and for MapFinal
+	outs := make([]memoryEmitter, len(inputs)+1)
+
+	work := make(chan int)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < mappers; i++ {
+		wg.Add(1)
+		go func() {
+			for index := range work {
+				emitter := mapperEmitter(mrjob, &outs[index])
+				mapper(mrjob, inputs[index], emitter)
+				emitter.Flush()
+			}
+			wg.Done()
+		}()
+	}
+
+	for i := range inputs {
+		work <- i
+	}
+	close(work)
+
+	wg.Wait()
+
+	if err := run.failedFast(); err != nil {
+		return err
+	}
+
+	emitter := mapperEmitter(mrjob, &outs[len(inputs)])
+	mapperFinal(mrjob, emitter)
+	emitter.Flush()
+
+	var kvs []KeyValue
+	for _, out := range outs {
+		kvs = append(kvs, out.KeyValues...)
+	}
+
+	sortKeyValues(kvs, isStable(mrjob))
+
+	w := bufio.NewWriter(output)
+	reduceRecords(mrjob, &sliceRecordReader{kvs}, teeEmitter(newPrintEmitter(w)))
+
+	if err := run.failedFast(); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// memoryEmitter collects the map output of RunReaders.  There's only the one
+// partition, so EmitAll emits the pair once.
+type memoryEmitter struct {
+	SliceEmitter
+}
+
+// EmitAll implements the BroadcastEmitter interface
+func (e *memoryEmitter) EmitAll(key string, value string) {
+	e.Emit(key, value)
+}
+
+// defaultFlags makes sure the flags have their defaults when we're run without Main
+func defaultFlags() {
+	if flagSet == nil {
+		RegisterFlags(flag.NewFlagSet("dmrgo", flag.ContinueOnError))
+	}
+}
@@ -0,0 +1,123 @@
+package dmrgo
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunResultPhases(t *testing.T) {
+
+	res, _, err := runLocal(t, wordJob{}, []string{"a b\n", "b c\n"}, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reducers only start once the mappers are done
+	p := res.Phases
+	if p.MapEnd <= 0 || p.ReduceStart < p.MapEnd || p.ReduceEnd < p.ReduceStart || p.ReduceEnd > res.WallTime || p.Overlap != 0 {
+		t.Errorf("map/reduce phases %+v in %v", p, res.WallTime)
+	}
+
+	res, _, err = runLocal(t, wordJob{}, []string{"a b\n"}, &RunOptions{MapOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := res.Phases; p.MapEnd <= 0 || p.ReduceStart != 0 || p.ReduceEnd != 0 || p.Overlap != 0 {
+		t.Errorf("map-only phases %+v, want only MapEnd", p)
+	}
+}
+
+func TestPhaseOverlap(t *testing.T) {
+
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	tests := []struct {
+		mapEnd, reduceStart, reduceEnd int
+		overlap                        time.Duration
+	}{
+		{10, 12, 20, 0},
+		{10, 10, 20, 0},
+		{10, 5, 20, 5 * time.Millisecond},
+		{10, 2, 6, 4 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		var reduce phaseSpan
+		reduce.add(at(tt.reduceStart), at(tt.reduceEnd))
+
+		if p := newPhaseTimes(start, at(tt.mapEnd), &reduce); p.Overlap != tt.overlap {
+			t.Errorf("map until %dms, reduce %d-%dms: overlap %v, want %v", tt.mapEnd, tt.reduceStart, tt.reduceEnd, p.Overlap, tt.overlap)
+		}
+	}
+
+	// nothing reduced
+	if p := newPhaseTimes(start, at(10), new(phaseSpan)); p.ReduceStart != 0 || p.ReduceEnd != 0 || p.Overlap != 0 {
+		t.Errorf("with nothing reduced, phases %+v", p)
+	}
+}
+
+// combinedJob counts words in the mapper, flushing the counts as a Combiner,
+// and broadcasts a count of the lines
+type combinedJob struct {
+	wordJob
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (j *combinedJob) Map(key string, value string, emitter Emitter) {
+
+	emitter.(BroadcastEmitter).EmitAll("(lines)", "1")
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, w := range strings.Fields(value) {
+		j.counts[w]++
+	}
+}
+
+func (j *combinedJob) CombineSize() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.counts)
+}
+
+func (j *combinedJob) CombineFlush(emitter Emitter) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for w, n := range j.counts {
+		emitter.Emit(w, strconv.Itoa(n))
+	}
+	j.counts = make(map[string]int)
+}
+
+func TestRunReaders(t *testing.T) {
+
+	quietStderr(t)
+	setFlag(t, "mappers", "2")
+	setFlag(t, "combine-threshold", "2")
+
+	inputs := []io.Reader{
+		strings.NewReader("a b a\nc\n"),
+		strings.NewReader("b b\n"),
+		strings.NewReader("c a d\n"),
+	}
+
+	var out bytes.Buffer
+	if err := RunReaders(&combinedJob{counts: make(map[string]int)}, inputs, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.String(), "(lines)\t4\na\t3\nb\t3\nc\t2\nd\t1\n"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
@@ -6,13 +6,19 @@ package dmrgo
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"path/filepath"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // KeyValue is the primary type for interacting with Hadoop.
@@ -21,31 +27,81 @@ type KeyValue struct {
 	Value string
 }
 
-func readLineValue(br *bufio.Reader) (*KeyValue, error) {
+// readLine returns the next line, without its newline.  A final line without a
+// trailing newline is still returned; io.EOF is only returned once the input is exhausted.
+func readLine(br *bufio.Reader) (string, error) {
 	s, err := br.ReadString('\n')
-	s = strings.TrimRight(s, "\n")
+	if err != nil && (err != io.EOF || s == "") {
+		return "", err
+	}
+	return strings.TrimSuffix(s, "\n"), nil
+}
+
+// ReadValue reads the next line as a value with an empty key, as the mapper does.
+// At the end of the input, it returns io.EOF.
+func ReadValue(br *bufio.Reader) (*KeyValue, error) {
+	s, err := readLine(br)
 	if err != nil {
 		return nil, err
 	}
-	return &KeyValue{"", s}, err
+	return &KeyValue{"", s}, nil
 }
 
-func readLineKeyValue(br *bufio.Reader) (*KeyValue, error) {
+// lineRecordReader reads key/value records from tab-separated lines
+type lineRecordReader struct {
+	br *bufio.Reader
+}
+
+func (r *lineRecordReader) ReadRecord() (*KeyValue, error) {
+	return ReadKeyValue(r.br)
+}
 
-	k, err := br.ReadString('\t')
+// the separator between keys and values in the line format.  It may be more than one byte.
+// sort(1) orders whole lines, so unless the separator sorts before every byte of
+// the keys, the lines for a key can be interleaved with those of a longer key it
+// prefixes: with "::", key "a" and key "a:" mix if a value starts below ':'.
+// Such keys need -intermediate framed, which sorts by key.
+var optKVSeparator = "\t"
+
+// ReadKeyValue reads the next line, splitting it into a key and value at the first
+// separator (a tab, unless -kv-separator says otherwise), as the reducer does.  As
+// with Hadoop streaming, a line without a separator is all key.  The key and value
+// are unescaped as WriteKeyValue escaped them.  At the end of the input, it returns io.EOF.
+func ReadKeyValue(br *bufio.Reader) (*KeyValue, error) {
+
+	s, err := readLine(br)
 	if err != nil {
 		return nil, err
 	}
 
-	v, err := br.ReadString('\n')
-	if err != nil {
-		return nil, err
+	e := lineEscaper()
+
+	i := strings.Index(s, optKVSeparator)
+	if i == -1 {
+		return &KeyValue{e.Unescape(s), ""}, nil
 	}
 
-	k = strings.TrimRight(k, "\t")
-	v = strings.TrimRight(v, "\n")
+	return &KeyValue{e.Unescape(s[:i]), e.Unescape(s[i+len(optKVSeparator):])}, nil
+}
+
+// WriteKeyValue writes kv as a line, with the key and value separated as for
+// ReadKeyValue, to be read back by it.  The key and value are escaped with the
+// -escape escaper, or LineEscaper; without one, the key must not contain the
+// separator, and neither may contain newlines.
+func WriteKeyValue(w *bufio.Writer, kv KeyValue) error {
+	e := lineEscaper()
+	w.WriteString(e.Escape(kv.Key))
+	w.WriteString(optKVSeparator)
+	w.WriteString(e.Escape(kv.Value))
+	return w.WriteByte('\n')
+}
 
-	return &KeyValue{k, v}, nil
+// readError reports an error reading input.  A clean io.EOF is not an error.
+func readError(err error) {
+	if err != io.EOF {
+		fmt.Fprintln(os.Stderr, "err reading input: ", err)
+		IncrCounter("dmrgo", "read errors", 1)
+	}
 }
 
 // MapReduceJob is the interface expected by the job runner
@@ -58,6 +114,57 @@ type MapReduceJob interface {
 	Reduce(key string, values []string, emitter Emitter)
 }
 
+// Combiner can be implemented by jobs which do in-mapper combining.  The job
+// holds per-key state between calls to Map, and the runner calls CombineFlush
+// to emit and discard that state whenever CombineSize reaches the
+// -combine-threshold, and once more before MapFinal.
+type Combiner interface {
+	// CombineSize returns the number of entries the job is holding
+	CombineSize() int
+
+	// CombineFlush emits and discards all held entries
+	CombineFlush(emitter Emitter)
+}
+
+// KeyNormalizer can be implemented by jobs which want keys grouped by a normalized
+// form, such as case-insensitively.  The reducer groups on, and passes to Reduce,
+// the normalized key.  Keys which normalize to the same value must still be
+// adjacent in the sorted reducer input, so it's usually best to also emit
+// normalized keys from the mapper.
+type KeyNormalizer interface {
+	NormalizeKey(key string) string
+}
+
+// ValueSorter can be implemented by jobs which want the values for each key in
+// sorted order.  The runner sorts the values with LessValues before calling
+// Reduce.  Jobs happy with byte-wise order can embed SortedValues.
+type ValueSorter interface {
+	LessValues(a, b string) bool
+}
+
+// SortedValues can be embedded in a job to have its values sorted byte-wise
+type SortedValues struct{}
+
+// LessValues implements the ValueSorter interface
+func (SortedValues) LessValues(a, b string) bool { return a < b }
+
+// FieldReducer can be implemented by jobs with composite keys, made of fields
+// joined by the -key-field-separator (by default the ASCII unit separator,
+// 0x1f, which can't be confused with the -kv-separator).  The runner splits
+// the key into its fields and calls ReduceFields in place of Reduce.
+type FieldReducer interface {
+	ReduceFields(keyFields []string, values []string, emitter Emitter)
+}
+
+// the separator between the fields of a composite key, for FieldReducer
+var optKeyFieldSeparator string
+
+// ReduceFinalizer can be implemented by jobs which need to emit values at the end of the Reduce phase.
+// In standalone map/reduce mode, ReduceFinal is called once per partition.
+type ReduceFinalizer interface {
+	ReduceFinal(emitter Emitter)
+}
+
 // are in we in the map or reduce phase?
 var optDoMap bool
 var optDoReduce bool
@@ -74,84 +181,241 @@ var optNumMappers int
 // how many concurrent reducers should we try to use
 var optNumReducers int
 
-func init() {
-	flag.BoolVar(&optDoMap, "mapper", false, "run mapper code on stdin")
-	flag.BoolVar(&optDoReduce, "reducer", false, "run reducer on stdin")
-	flag.IntVar(&optNumPartitions, "partitions", 1, "parition data into sets")
-	flag.BoolVar(&optDoMapReduce, "mapreduce", false, "run full map/reduce")
-	flag.IntVar(&optNumMappers, "mappers", 4, "number of map processes")
-	flag.IntVar(&optNumReducers, "reducers", 4, "number of reducer processes")
+// the sort binary to use
+var optSortBin string
+
+// the sort command to run, with {inputs} and {output} placeholders, overriding -sort-bin
+var optSortCmd string
+
+// how we find the sort binary -- a variable so it can be replaced for testing
+var lookPath = exec.LookPath
+
+// how many concurrent sort processes should we run (0 means one per reducer)
+var optSortConcurrency int
+
+// how many inputs can be queued for the mappers (0 to hand them over one at a time)
+var optMapperQueue int
+
+// kill a sort which takes longer than this (0 for no limit)
+var optSortTimeout time.Duration
+
+// should the reducer verify keys belong to the partition being reduced
+var optVerifyPartitions bool
+
+// how many combiner entries can be held before flushing (0 means only flush at the end)
+var optCombineThreshold int
+
+// should we keep the intermediate files around for debugging
+var optKeepTemp bool
+
+// how many records to map from each input (0 means unlimited)
+var optLimit int
+
+// should the mapper count empty input lines
+var optCountEmptyLines bool
+
+// should the reducer verify its input is sorted
+var optCheckSorted bool
+
+// stop reducing each partition after this many of its groups have emitted output (0 for no limit)
+var optReduceGroupLimit int
+
+// write the reducer output to stdout instead of leaving it in files
+var optStdout bool
+
+// mapreduce runs the standalone map/reduce over the files named on the command
+// line, prints a summary of the run, and returns the exit status
+func mapreduce(mrjob MapReduceJob) int {
+
+	res, err := runMapReduce(mrjob, flagSet.Args())
+	if _, ok := err.(*failedFastError); ok {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	if res.Files != nil {
+		counts := make([]string, len(res.Files))
+		for i, o := range res.Files {
+			counts[i] = strconv.FormatInt(o.Records, 10)
+		}
+		Statusf("reduce output records: %d (%s)", res.Records, strings.Join(counts, " "))
+	}
+
+	if optStdout {
+		catOutputs(res.Files)
+		if len(res.Errors) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	if len(res.Outputs) == 1 {
+		fmt.Printf("output is in: %s\n", res.Outputs[0])
+	} else {
+		fmt.Printf("output is in: %s - %s\n", res.Outputs[0], res.Outputs[len(res.Outputs)-1])
+	}
+
+	// some inputs or partitions failed, and have been reported already
+	if len(res.Errors) > 0 {
+		return 1
+	}
+
+	return 0
 }
 
-func mapreduce(mrjob MapReduceJob) {
+// runMapReduce runs the standalone map/reduce over the inputs, or stdin if
+// there are none.  Errors setting up the run, and the first error of a run
+// stopped by -fail-fast, are returned; other errors are in the result.
+func runMapReduce(mrjob MapReduceJob, inputs []string) (*RunResult, error) {
+
+	start := time.Now()
 
-	attr := new(os.ProcAttr)
-	attr.Files = []*os.File{nil, nil, nil}
+	resetCounters()
 
 	pid := os.Getpid()
 
+	runID := taskRunID(pid)
+
+	if err := checkCompress(); err != nil {
+		return nil, err
+	}
+
+	if optIntermediate != "lines" && optIntermediate != "framed" {
+		return nil, errors.New("unknown format for -intermediate: " + optIntermediate)
+	}
+
+	if _, ok := checksums[optChecksum]; optChecksum != "" && !ok {
+		return nil, errors.New("unknown checksum for -checksum: " + optChecksum)
+	}
+
+	if optCombineOnMerge && isStable(mrjob) {
+		return nil, errors.New("can't use -combine-on-merge with a job which keeps values in emit order")
+	}
+
+	if optAppend && optStdout {
+		return nil, errors.New("can't use -append with -stdout")
+	}
+
+	// appending needs output names which are the same from run to run
+	if optAppend {
+		runID = ""
+	}
+
+	run := newRunState()
+	setCurrentRun(run)
+	defer setCurrentRun(nil)
+
+	defer flushRejects()
+
+	removeSuccessMarker()
+
+	// no reducers -- a map-only job
+	if optNumReducers == 0 {
+		return mapOnly(mrjob, inputs, runID, run)
+	}
+
+	// make sure we can sort before doing all the map work
+	var sortPath string
+	var sortArgs []string
+	if optIntermediate == "lines" {
+		var err error
+		if sortArgs, err = sortTemplate(); err != nil {
+			return nil, err
+		}
+		sortPath, err = lookPath(sortArgs[0])
+		if err != nil {
+			return nil, fmt.Errorf("can't find sort binary %q (use -sort-bin or -sort-cmd to set it): %v", sortArgs[0], err)
+		}
+		sortArgs = sortArgs[1:]
+	}
+
 	wg := new(sync.WaitGroup)
 
-	mapperInputFiles := flag.Args()
+	// the intermediate files written by the mappers, for each partition
+	var partitionFilesMu sync.Mutex
+	partitionFiles := make([][]string, optNumPartitions)
+
+	addPartitionFiles := func(pe *partitionEmitter) {
+		partitionFilesMu.Lock()
+		defer partitionFilesMu.Unlock()
+		for partition := range partitionFiles {
+			partitionFiles[partition] = append(partitionFiles[partition], pe.files(partition)...)
+		}
+	}
+
+	mapperInputFiles := inputs
+
+	// how many mappers ran concurrently
+	mappers := 1
 
 	// no input files -- read from stdin
 	if len(mapperInputFiles) == 0 {
-		mEmit := newPartitionEmitter(uint(optNumPartitions), fmt.Sprintf("tmp-map-out-p%d-f0", pid))
-		mapper(mrjob, os.Stdin, mEmit)
-		mapperFinal(mrjob, mEmit)
-		mEmit.Flush()
+		mEmit := newPartitionEmitter(run, uint(optNumPartitions), fmt.Sprintf("tmp-map-out-p%d-f0", pid))
+		emitter := mapperEmitter(mrjob, mEmit)
+		mapper(mrjob, os.Stdin, emitter)
+		mapperFinal(mrjob, emitter)
+		emitter.Flush()
 		mEmit.Close()
+		addPartitionFiles(mEmit)
 		mapperInputFiles = []string{"(stdin)"}
 	} else {
 		// we have multiple input files -- run up to 'mappers' of them in parallel
+		var n int
+		n, mappers = forEachInput(run, mapperInputFiles, func(index int, r io.Reader) int {
+			mEmit := newPartitionEmitter(run, uint(optNumPartitions), fmt.Sprintf("tmp-map-out-p%d-f%d", pid, index))
+			emitter := mapperEmitter(mrjob, mEmit)
+			records := mapper(mrjob, r, emitter)
+			emitter.Flush()
+			mEmit.Close()
+			addPartitionFiles(mEmit)
+			return records
+		})
 
-		// the type of our channel -- limit scope 'cause we don't need it anywhere else
-		type mapperFile struct {
-			index int
-			fname string
-		}
-
-		mapperWork := make(chan *mapperFile)
+		// then launch mapperFinal
+		mEmit := newPartitionEmitter(run, uint(optNumPartitions), fmt.Sprintf("tmp-map-out-p%d-f%d", pid, n))
+		emitter := mapperEmitter(mrjob, mEmit)
+		mapperFinal(mrjob, emitter)
+		emitter.Flush()
+		mEmit.Close()
+		addPartitionFiles(mEmit)
+	}
 
-		// launch the goroutines
-		for i := 0; i < optNumMappers; i++ {
-			wg.Add(1)
-			go func(inputs chan *mapperFile) {
+	// the files to remove if we fail fast
+	tempFiles := func() []string {
+		var files []string
+		for partition, fns := range partitionFiles {
+			files = append(files, fns...)
+			files = append(files, fmt.Sprintf("tmp-red-in-p%d.%04d", pid, partition))
+		}
+		return files
+	}
 
-				for input := range inputs {
+	if err := run.failedFast(); err != nil {
+		return nil, abortRun(err, tempFiles())
+	}
 
-					f, err := os.Open(input.fname)
-					if err != nil {
-						fmt.Fprintln(os.Stderr, "err opening ", f, ": ", err)
-						return
-					}
+	mapTime := time.Since(start)
 
-					mEmit := newPartitionEmitter(uint(optNumPartitions), fmt.Sprintf("tmp-map-out-p%d-f%d", pid, input.index))
-					mapper(mrjob, f, mEmit)
-					mEmit.Flush()
-					mEmit.Close()
-					f.Close()
-				}
-				wg.Done()
-			}(mapperWork)
-		}
+	// the sorts and reduces run concurrently, so these are the totals across all partitions
+	var sortTime, reduceTime phaseTimer
 
-		// and send the work
-		for i, fname := range mapperInputFiles {
-			mapperWork <- &mapperFile{i, fname}
-		}
-		close(mapperWork)
+	// when the first partition started sorting, and the last finished reducing
+	var reduceSpan phaseSpan
 
-		wg.Wait()
+	partitions := make(chan int)
 
-		// then launch mapperFinal
-		mEmit := newPartitionEmitter(uint(optNumPartitions), fmt.Sprintf("tmp-map-out-p%d-f%d", pid, len(mapperInputFiles)))
-		mapperFinal(mrjob, mEmit)
-		mEmit.Flush()
-		mEmit.Close()
+	// limit the number of sorts running at once
+	sortLimit := optSortConcurrency
+	if sortLimit <= 0 {
+		sortLimit = optNumReducers
 	}
+	sorts := make(chan struct{}, sortLimit)
 
-	partitions := make(chan int)
+	outputs := make([]OutputFile, optNumPartitions)
 
 	for i := 0; i < optNumReducers; i++ {
 
@@ -161,31 +425,146 @@ func mapreduce(mrjob MapReduceJob) {
 
 			for partition := range work {
 
-				fns, _ := filepath.Glob(fmt.Sprintf("tmp-map-out-p%d-f*.%04d", pid, partition))
+				if run.stopped() {
+					continue
+				}
+
+				fns := partitionFiles[partition]
 
 				redin := fmt.Sprintf("tmp-red-in-p%d.%04d", pid, partition)
 
-				cmdline := []string{"sort", "-o", redin}
-				cmdline = append(cmdline, fns...)
+				var records RecordReader
+				var f *os.File
 
-				// sort
-				p, err := os.StartProcess("/usr/bin/sort", cmdline, attr)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, "err running sort: ", err)
+				sortStart := time.Now()
+
+				if len(fns) == 0 {
+					// no mapper wrote to this partition, so there's nothing to sort.
+					// We still reduce, so ReduceFinal runs and the output file exists.
+					records = &sliceRecordReader{}
+				} else if optIntermediate == "framed" {
+					// framed records can't be sorted by sort(1), so sort them in memory
+					kvs, err := sortFramedFiles(fns, isStable(mrjob))
+					if err != nil {
+						// the sorted input would be incomplete, so fail the partition
+						fmt.Fprintf(os.Stderr, "err sorting partition %d: %v (intermediate files: %s)\n", partition, err, strings.Join(fns, " "))
+						run.fail(err)
+						continue
+					}
+					records = &sliceRecordReader{kvs}
+				} else {
+					// sort
+					sorts <- struct{}{}
+					err := runWithTimeout(sortCommand(sortPath, sortArgs, redin, fns), optSortTimeout)
+					<-sorts
+					if err != nil {
+						// the sorted input would be incomplete, so fail the partition
+						fmt.Fprintf(os.Stderr, "err running sort for partition %d: %v (intermediate files: %s)\n", partition, err, strings.Join(fns, " "))
+						run.fail(err)
+						os.Remove(redin)
+						continue
+					}
+
+					f, _ = os.Open(redin)
+					records = &lineRecordReader{bufio.NewReader(f)}
+				}
+
+				sortTime.add(sortStart)
+
+				records = checkProtoHeader(records)
+
+				if optChecksum != "" {
+					records = &checksumReader{r: records, h: checksums[optChecksum]()}
+				}
+
+				if optVerifyPartitions {
+					records = &partitionVerifier{r: records, partition: uint32(partition), partitions: uint32(optNumPartitions)}
+				}
+
+				if m, ok := mrjob.(ValueMerger); ok && optCombineOnMerge {
+					records = &combiningRecordReader{r: records, merge: m.MergeValues}
 				}
-				p.Wait()
 
 				// reduce
-				f, _ := os.Open(redin)
-				rout, _ := os.Create(fmt.Sprintf("red-out-p%d.%04d", pid, partition))
-				rEmit := newPrintEmitter(bufio.NewWriter(rout))
-				reducer(mrjob, f, rEmit)
-				for _, fn := range fns {
-					os.Remove(fn)
+				// write to a temporary file, and rename it into place when we're done.
+				// When appending without a merger, we have to write to the output directly.
+				routName := outputName(runID, partition)
+				routTmp := routName + ".tmp"
+				merger, _ := mrjob.(ValueMerger)
+				var prior map[string]string
+				var rout io.WriteCloser
+				var err error
+				if optAppend && merger == nil {
+					routTmp = ""
+					rout, err = appendMaybeCompressed(routName)
+				} else {
+					if optAppend {
+						if prior, err = readPriorOutput(routName); err != nil {
+							fmt.Fprintln(os.Stderr, "err reading previous output: ", err)
+							run.fail(err)
+							continue
+						}
+					}
+					rout, err = createMaybeCompressed(routTmp)
+				}
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "err creating output: ", err)
+					run.fail(err)
+					continue
+				}
+				rEmit := &statsEmitter{Emitter: newPrintEmitter(bufio.NewWriter(rout))}
+				var out Emitter = rEmit
+				if prior != nil {
+					out = newAppendMerger(rEmit, merger, prior)
+				}
+				reduceStart := time.Now()
+				reduceErr := reduceRecords(mrjob, records, teeEmitter(out))
+				if m, ok := out.(*appendMerger); ok {
+					m.emitPrior()
+				}
+				reduceTime.add(reduceStart)
+				reduceSpan.add(sortStart, time.Now())
+				if f != nil {
+					f.Close()
+				}
+				if optKeepTemp && len(fns) > 0 {
+					fmt.Fprintf(os.Stderr, "partition %d intermediate files: %s %s\n", partition, strings.Join(fns, " "), redin)
+				} else {
+					for _, fn := range fns {
+						os.Remove(fn)
+					}
+					os.Remove(redin)
 				}
-				os.Remove(redin)
 				rEmit.Flush()
-				rout.Close()
+				if err := rout.Close(); err != nil {
+					fmt.Fprintln(os.Stderr, "err writing output: ", err)
+					run.fail(err)
+					if routTmp != "" {
+						os.Remove(routTmp)
+					}
+					continue
+				}
+				if reduceErr != nil {
+					// the output would be silently incomplete, so don't commit it
+					run.fail(reduceErr)
+					if routTmp != "" {
+						os.Remove(routTmp)
+					}
+					continue
+				}
+				if routTmp != "" {
+					if err := os.Rename(routTmp, routName); err != nil {
+						fmt.Fprintln(os.Stderr, "err committing output: ", err)
+						run.fail(err)
+						os.Remove(routTmp)
+						continue
+					}
+				}
+
+				outputs[partition] = OutputFile{Path: routName, Partition: partition, Records: rEmit.records, MinKey: rEmit.minKey, MaxKey: rEmit.maxKey}
+				if fi, err := os.Stat(routName); err == nil {
+					outputs[partition].Bytes = fi.Size()
+				}
 			}
 			wg.Done()
 		}(partitions)
@@ -198,66 +577,499 @@ func mapreduce(mrjob MapReduceJob) {
 
 	wg.Wait()
 
-	if optNumPartitions == 1 {
-		fmt.Printf("output is in: red-out-p%d.0000\n", pid)
-	} else {
-		fmt.Printf("output is in: red-out-p%d.0000 - red-out-p%d.%04d\n", pid, pid, optNumPartitions-1)
+	if err := run.failedFast(); err != nil {
+		files := tempFiles()
+		for partition := 0; partition < optNumPartitions; partition++ {
+			files = append(files, outputName(runID, partition)+".tmp")
+			// don't throw away the output of earlier runs
+			if !optAppend {
+				files = append(files, outputName(runID, partition))
+			}
+		}
+		return nil, abortRun(err, files)
+	}
+
+	var records int64
+	names := make([]string, len(outputs))
+	for i, o := range outputs {
+		records += o.Records
+		names[i] = outputName(runID, i)
+	}
+
+	if optTiming {
+		IncrCounter("dmrgo", "mappers", mappers)
 	}
+	reportTiming("map", mapTime)
+	reportTiming("sort", sortTime.duration())
+	reportTiming("reduce", reduceTime.duration())
+
+	phases := newPhaseTimes(start, start.Add(mapTime), &reduceSpan)
+	reportTiming("map/reduce overlap", phases.Overlap)
+
+	res := &RunResult{
+		Manifest: Manifest{
+			Files:      outputs,
+			Mappers:    mappers,
+			Records:    records,
+			WallTime:   time.Since(start),
+			MapTime:    mapTime,
+			SortTime:   sortTime.duration(),
+			ReduceTime: reduceTime.duration(),
+		},
+		Phases:   phases,
+		Outputs:  names,
+		Counters: counterTotals(),
+		Errors:   run.errors(),
+	}
+
+	if optManifest != "" {
+		if err := writeManifest(optManifest, &res.Manifest); err != nil {
+			fmt.Fprintln(os.Stderr, "err writing manifest: ", err)
+		}
+	}
+
+	writeSuccessMarker(run)
+
+	return res, nil
+}
+
+// catOutputs copies the output files to stdout in partition order, removing them as it goes
+func catOutputs(outputs []OutputFile) {
+
+	stdout := bufio.NewWriter(os.Stdout)
+
+	for _, o := range outputs {
+		if o.Path == "" {
+			// this partition failed, and we've already complained
+			continue
+		}
+
+		f, err := os.Open(o.Path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "err opening output: ", err)
+			continue
+		}
+
+		_, err = io.Copy(stdout, f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "err copying output: ", err)
+			continue
+		}
+
+		os.Remove(o.Path)
+	}
+
+	stdout.Flush()
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// counterName makes s safe to use as a counter name.  The counter protocol
+// separates the group, name and amount with commas, and ends with a newline.
+func counterName(s string) string {
+	return strings.NewReplacer(",", "_", "\n", "_").Replace(s)
+}
+
+// runWithTimeout runs cmd, killing it if it takes longer than timeout (0 for no limit)
+func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+
+	if timeout <= 0 {
+		return cmd.Run()
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("%s timed out after %v", cmd.Path, timeout)
+	}
+}
+
+// sortTemplate returns the sort command from -sort-cmd, split into fields, or
+// the -sort-bin command if it wasn't given.  The fields must include {inputs},
+// which is replaced by the intermediate files, and {output}, which is replaced
+// by the file the sorted records should be written to.  There is no quoting:
+// fields are separated by spaces.
+func sortTemplate() ([]string, error) {
+
+	if optSortCmd == "" {
+		return []string{optSortBin, "-o", "{output}", "{inputs}"}, nil
+	}
+
+	fields := strings.Fields(optSortCmd)
+
+	var inputs, outputs int
+	for _, f := range fields {
+		switch f {
+		case "{inputs}":
+			inputs++
+		case "{output}":
+			outputs++
+		}
+	}
+
+	if len(fields) == 0 || inputs != 1 || outputs != 1 || fields[0] == "{inputs}" || fields[0] == "{output}" {
+		return nil, fmt.Errorf("-sort-cmd %q needs a command, and {inputs} and {output} once each", optSortCmd)
+	}
+
+	return fields, nil
+}
+
+// sortCommand returns the command to sort the inputs into output, filling in
+// the placeholders in args.  The sort runs in the C locale so it orders keys
+// byte-wise, as Hadoop does, rather than with the locale's collation (where "a"
+// and "B" sort together).  Settings later in Env take precedence, so these
+// override any inherited from our environment.
+func sortCommand(sortPath string, args []string, output string, inputs []string) *exec.Cmd {
+	var cmdArgs []string
+	for _, a := range args {
+		switch a {
+		case "{inputs}":
+			cmdArgs = append(cmdArgs, inputs...)
+		case "{output}":
+			cmdArgs = append(cmdArgs, output)
+		default:
+			cmdArgs = append(cmdArgs, a)
+		}
+	}
+	cmd := exec.Command(sortPath, cmdArgs...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "LC_COLLATE=C")
+	return cmd
+}
+
+// forEachInput calls fn for each of the input files, running up to 'mappers' of them in parallel.
+// The file name '-' means stdin.  A single large input file is split into byte ranges,
+// so it still uses all the mappers.  Unless -mappers is given, up to twice the number
+// of CPUs are used, but no more than there is work for.  It returns the number of
+// times fn was called, and the number of mappers used.
+func forEachInput(run *runState, inputs []string, fn func(index int, r io.Reader) int) (int, int) {
+
+	mappers := optNumMappers
+	if mappers <= 0 {
+		mappers = 2 * runtime.NumCPU()
+	}
+
+	// the type of our channel -- limit scope 'cause we don't need it anywhere else
+	type mapperInput struct {
+		index int
+		fname string
+		open  func() (io.ReadCloser, error)
+	}
+
+	var work []*mapperInput
+
+	if len(inputs) == 1 {
+		fname := inputs[0]
+		for i, split := range splitInput(fname, mappers) {
+			split := split
+			work = append(work, &mapperInput{i, fname, func() (io.ReadCloser, error) { return openSplit(fname, split) }})
+		}
+	}
+
+	if work == nil {
+		for i, fname := range inputs {
+			fname := fname
+			open := func() (io.ReadCloser, error) { return openMaybeCompressed(fname) }
+			// '-' means read from stdin
+			if fname == "-" {
+				open = func() (io.ReadCloser, error) { return ioutil.NopCloser(os.Stdin), nil }
+			}
+			work = append(work, &mapperInput{i, fname, open})
+		}
+	}
+
+	if optNumMappers <= 0 && mappers > len(work) {
+		mappers = len(work)
+	}
+
+	mapperWork := make(chan *mapperInput, optMapperQueue)
+
+	wg := new(sync.WaitGroup)
+
+	// launch the goroutines
+	for i := 0; i < mappers; i++ {
+		wg.Add(1)
+		go func(inputs chan *mapperInput) {
+
+			for input := range inputs {
+
+				if run.stopped() {
+					// keep draining the queue, so the sender doesn't block
+					continue
+				}
+
+				f, err := input.open()
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "err opening ", input.fname, ": ", err)
+					run.fail(err)
+					continue
+				}
+
+				cr := &countingReader{r: f}
+				records := fn(input.index, cr)
+				IncrCounter("dmrgo input records", counterName(input.fname), records)
+				IncrCounter("dmrgo input bytes", counterName(input.fname), int(cr.n))
+
+				f.Close()
+			}
+			wg.Done()
+		}(mapperWork)
+	}
+
+	// and send the work
+	for _, w := range work {
+		mapperWork <- w
+	}
+	close(mapperWork)
+
+	wg.Wait()
+
+	return len(work), mappers
+}
+
+// mapOnly runs a job without a reduce phase: the mapper output for each input
+// file is written directly to an output file, without partitioning or sorting.
+func mapOnly(mrjob MapReduceJob, inputs []string, runID string, run *runState) (*RunResult, error) {
+
+	start := time.Now()
+
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	n, mappers := forEachInput(run, inputs, func(index int, r io.Reader) int {
+		var records int
+		writeOutput(run, outputName(runID, index), func(e Emitter) { records = mapper(mrjob, r, e) })
+		return records
+	})
+
+	if err := run.failedFast(); err != nil {
+		var files []string
+		for i := 0; i < n; i++ {
+			files = append(files, outputName(runID, i), outputName(runID, i)+".tmp")
+		}
+		return nil, abortRun(err, files)
+	}
+
+	// MapFinal gets an output file of its own
+	writeOutput(run, outputName(runID, n), func(e Emitter) { mapperFinal(mrjob, e) })
+
+	names := make([]string, n+1)
+	for i := range names {
+		names[i] = outputName(runID, i)
+	}
+
+	writeSuccessMarker(run)
+
+	return &RunResult{
+		Manifest: Manifest{Mappers: mappers, WallTime: time.Since(start), MapTime: time.Since(start)},
+		Phases:   PhaseTimes{MapEnd: time.Since(start)},
+		Outputs:  names,
+		Counters: counterTotals(),
+		Errors:   run.errors(),
+	}, nil
+}
+
+// writeOutput calls fn with an Emitter writing to a temporary file, which is renamed to fname when done
+func writeOutput(run *runState, fname string, fn func(e Emitter)) {
+
+	tmp := fname + ".tmp"
+
+	w, err := createMaybeCompressed(tmp)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "err creating output: ", err)
+		run.fail(err)
+		return
+	}
+
+	e := newPrintEmitter(bufio.NewWriter(w))
+	fn(e)
+	e.Flush()
+
+	if err := w.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "err writing output: ", err)
+		run.fail(err)
+		os.Remove(tmp)
+		return
+	}
+
+	if err := os.Rename(tmp, fname); err != nil {
+		fmt.Fprintln(os.Stderr, "err committing output: ", err)
+		run.fail(err)
+		os.Remove(tmp)
+	}
+}
+
+// taskRunID returns the Hadoop task attempt id if we have one, so speculative
+// attempts of the same task don't clobber each other's output.  Otherwise, the
+// process id is used.
+func taskRunID(pid int) string {
+	for _, env := range []string{"mapreduce_task_attempt_id", "mapred_task_id"} {
+		if id := os.Getenv(env); id != "" {
+			return id
+		}
+	}
+	return fmt.Sprintf("p%d", pid)
+}
+
+// the name of the reducer output file for a partition
+func outputName(runID string, partition int) string {
+	if runID == "" {
+		return fmt.Sprintf("red-out.%04d%s", partition, optCompress)
+	}
+	return fmt.Sprintf("red-out-%s.%04d%s", runID, partition, optCompress)
 }
 
 // Main runs the map reduce job passed in
 func Main(mrjob MapReduceJob) {
 
+	if flagSet == nil {
+		RegisterFlags(flag.CommandLine)
+		flag.Parse()
+	}
+
+	if status := runMain(mrjob); status != 0 {
+		os.Exit(status)
+	}
+}
+
+// runMain runs the job as the flags ask, and returns the exit status.  It
+// returns rather than exiting, so the profiles and rejects are always flushed.
+func runMain(mrjob MapReduceJob) int {
+
+	if _, ok := escapers[optEscape]; !ok {
+		fmt.Println("unknown escaper for -escape:", optEscape)
+		return 1
+	}
+
+	stopProfiling := startProfiling()
+	defer stopProfiling()
+	defer flushRejects()
+
 	if optDoMapReduce {
-		mapreduce(mrjob)
-		return
+		return mapreduce(mrjob)
 	}
 
 	if optDoMap && optDoReduce {
 		fmt.Println("can either map or reduce, not both. (Did  you mean --mapreduce ?)")
-		os.Exit(1)
+		return 1
 	}
 
 	if !optDoMap && !optDoReduce {
 		fmt.Println("neither map nor reduce called")
-		os.Exit(1)
+		return 1
 	}
 
+	// for -on-bad-record fail
+	run := newRunState()
+	setCurrentRun(run)
+	defer setCurrentRun(nil)
+
 	stdout := bufio.NewWriter(os.Stdout)
 
-	emitter := newPrintEmitter(stdout)
+	emitter := teeEmitter(newPrintEmitter(stdout))
+
+	input, closeInput, err := streamInput(flagSet.Args())
+	if err != nil {
+		fmt.Println("error opening input:", err)
+		return 1
+	}
+	defer closeInput()
+
+	start := time.Now()
 
 	if optDoMap {
-		mapper(mrjob, os.Stdin, emitter)
+		mEmit := mapperStable(mrjob, emitter)
+		mapper(mrjob, input, mEmit)
 		// handle any finalization from the mapper
-		mapperFinal(mrjob, emitter)
+		mapperFinal(mrjob, mEmit)
+		reportTiming("map", time.Since(start))
 	}
 
 	if optDoReduce {
-		reducer(mrjob, os.Stdin, emitter)
+		reducer(mrjob, input, emitter)
+		reportTiming("reduce", time.Since(start))
 	}
 
 	emitter.Flush()
+
+	// the errors have already been reported
+	if len(run.errors()) > 0 {
+		return 1
+	}
+
+	return 0
 }
 
 // run the mapping phase, calling the map routine on key/value pairs from the Reader
 // The users' Map routine will write any key/value pairs generated to the Emitter
-func mapper(mrjob MapReduceJob, r io.Reader, emitter Emitter) {
+// It returns the number of records mapped.
+func mapper(mrjob MapReduceJob, r io.Reader, emitter Emitter) int {
 
 	br := bufio.NewReader(r)
 
-	for {
-		kv, err := readLineValue(br)
+	combiner, _ := mrjob.(Combiner)
+
+	var records int
+
+	for ; optLimit == 0 || records < optLimit; records++ {
+		kv, err := ReadValue(br)
 		if err != nil {
+			readError(err)
 			break
 		}
 
+		if optCountEmptyLines && kv.Value == "" {
+			IncrCounter("dmrgo", "empty input lines", 1)
+		}
+
 		mrjob.Map("", kv.Value, emitter)
+
+		if combiner != nil && optCombineThreshold > 0 && combiner.CombineSize() >= optCombineThreshold {
+			combiner.CombineFlush(emitter)
+		}
 	}
+
+	return records
 }
 
 // run the cleanup phase for the mapper
 func mapperFinal(mrjob MapReduceJob, emitter Emitter) {
+	if combiner, ok := mrjob.(Combiner); ok {
+		combiner.CombineFlush(emitter)
+	}
 	mrjob.MapFinal(emitter)
 }
 
@@ -265,30 +1077,137 @@ func mapperFinal(mrjob MapReduceJob, emitter Emitter) {
 // We aggregate the values that have been mapped with the same key, then call the users' Reduce function.
 // The users' Reduce routine will output any key/value pairs via the Emitter.
 func reducer(mrjob MapReduceJob, r io.Reader, emitter Emitter) {
+	reduceRecords(mrjob, &lineRecordReader{bufio.NewReader(r)}, emitter)
+}
 
-	br := bufio.NewReader(r)
+// run the reduce phase over records from the RecordReader, which must be sorted by key.
+// It returns the error which ended the input early, if any; the groups read before it are still reduced.
+func reduceRecords(mrjob MapReduceJob, records RecordReader, emitter Emitter) error {
 
+	// the empty string is a valid key, so track whether we have one separately
 	var currentKey string
+	var hasCurrent bool
 	values := []string{}
 
+	normalizer, _ := mrjob.(KeyNormalizer)
+
+	// with -reduce-group-limit, count the groups which emitted something
+	var groups int
+	counter := &countingEmitter{Emitter: emitter}
+	if optReduceGroupLimit > 0 {
+		emitter = counter
+	}
+
+	var readErr error
+
+	timer := newReduceTimer()
+	sizes := newGroupSizes()
+	reduceCurrent := func() {
+		sizes.add(len(values))
+		var start time.Time
+		if timer != nil {
+			start = time.Now()
+		}
+		reduce(mrjob, currentKey, values, emitter)
+		timer.done(currentKey, start)
+	}
+
 	for {
-		mkv, err := readLineKeyValue(br)
+		mkv, err := records.ReadRecord()
 		if err != nil {
+			readError(err)
+			if err != io.EOF {
+				readErr = err
+			}
 			break
 		}
 
-		if currentKey == mkv.Key {
+		key := mkv.Key
+		if normalizer != nil {
+			key = normalizer.NormalizeKey(key)
+		}
+
+		if optCheckSorted && hasCurrent && key < currentKey {
+			badRecord("unsorted reduce keys", "key %q after %q", key, currentKey)
+		}
+
+		if hasCurrent && currentKey == key {
 			values = append(values, mkv.Value)
 		} else {
-			if currentKey != "" {
-				mrjob.Reduce(currentKey, values, emitter)
+			if hasCurrent {
+				before := counter.n
+				reduceCurrent()
 				values = []string{}
+				if counter.n > before {
+					groups++
+				}
+				if optReduceGroupLimit > 0 && groups >= optReduceGroupLimit {
+					// the group we've just started is past the limit
+					hasCurrent = false
+					break
+				}
 			}
-			currentKey = mkv.Key
+			currentKey = key
+			hasCurrent = true
 			values = append(values, mkv.Value)
 		}
 	}
 
 	// final reducer call with pending 'values'
-	mrjob.Reduce(currentKey, values, emitter)
+	if hasCurrent {
+		reduceCurrent()
+	}
+
+	timer.report()
+	sizes.report()
+
+	reducerFinal(mrjob, emitter)
+
+	return readErr
+}
+
+// countingEmitter counts the key/value pairs passing through it
+type countingEmitter struct {
+	Emitter
+	n int
+}
+
+func (e *countingEmitter) Emit(key string, value string) {
+	e.n++
+	e.Emitter.Emit(key, value)
+}
+
+// reduce calls the job's Reduce, ReduceFields or ReduceLazy, first stripping any sequence
+// numbers from the values and sorting them, if the job wants that
+func reduce(mrjob MapReduceJob, key string, values []string, emitter Emitter) {
+	if isStable(mrjob) {
+		stripSequence(values)
+	}
+	if s, ok := mrjob.(ValueSorter); ok {
+		sort.SliceStable(values, func(i, j int) bool { return s.LessValues(values[i], values[j]) })
+	}
+	if f, ok := mrjob.(FieldReducer); ok {
+		f.ReduceFields(strings.Split(key, optKeyFieldSeparator), values, emitter)
+		return
+	}
+	if l, ok := mrjob.(LazyReducer); ok {
+		l.ReduceLazy(key, NewLazyValues(values), emitter)
+		return
+	}
+	mrjob.Reduce(key, values, emitter)
+}
+
+// normalize the key, if the job wants that
+func normalizeKey(mrjob MapReduceJob, key string) string {
+	if n, ok := mrjob.(KeyNormalizer); ok {
+		return n.NormalizeKey(key)
+	}
+	return key
+}
+
+// run the cleanup phase for the reducer, if the job has one
+func reducerFinal(mrjob MapReduceJob, emitter Emitter) {
+	if rf, ok := mrjob.(ReduceFinalizer); ok {
+		rf.ReduceFinal(emitter)
+	}
 }
@@ -0,0 +1,41 @@
+package dmrgo
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkManyTinyFiles maps many one-line files, where handing each file to
+// a mapper costs about as much as mapping it
+func BenchmarkManyTinyFiles(b *testing.B) {
+
+	quietStderr(b)
+
+	dir := b.TempDir()
+	var inputs []string
+	for i := 0; i < 1000; i++ {
+		fname := filepath.Join(dir, fmt.Sprintf("in%04d", i))
+		if err := ioutil.WriteFile(fname, []byte("tiny\n"), 0644); err != nil {
+			b.Fatal(err)
+		}
+		inputs = append(inputs, fname)
+	}
+
+	queue := optMapperQueue
+	defer func() { optMapperQueue = queue }()
+
+	for _, depth := range []int{0, 16, 256} {
+		b.Run(fmt.Sprintf("queue=%d", depth), func(b *testing.B) {
+			optMapperQueue = depth
+			for i := 0; i < b.N; i++ {
+				forEachInput(newRunState(), inputs, func(index int, r io.Reader) int {
+					io.Copy(ioutil.Discard, r)
+					return 1
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package dmrgo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMapperCountsEmptyLines(t *testing.T) {
+
+	quietStderr(t)
+
+	for _, count := range []bool{false, true} {
+
+		if count {
+			setFlag(t, "count-empty", "true")
+		}
+
+		resetCounters()
+
+		var e SliceEmitter
+		records := mapper(wordJob{}, strings.NewReader("a b\n\nc\n\n\nd"), &e)
+
+		if records != 6 {
+			t.Errorf("count-empty=%v: mapped %d records, want 6", count, records)
+		}
+
+		want := int64(0)
+		if count {
+			want = 3
+		}
+		if got := counterTotals()["dmrgo"]["empty input lines"]; got != want {
+			t.Errorf("count-empty=%v: empty input lines=%d, want %d", count, got, want)
+		}
+
+		if len(e.KeyValues) != 4 {
+			t.Errorf("count-empty=%v: emitted %v, want the 4 words", count, e.KeyValues)
+		}
+	}
+}
+
+func TestReduceChecksSorted(t *testing.T) {
+
+	quietStderr(t)
+
+	setFlag(t, "check-sorted", "true")
+	setFlag(t, "on-bad-record", "count")
+
+	resetCounters()
+
+	kvs := []KeyValue{{"a", "1"}, {"b", "1"}, {"a", "1"}, {"c", "1"}}
+
+	var e SliceEmitter
+	reduceRecords(wordJob{}, &sliceRecordReader{kvs}, &e)
+
+	if got := counterTotals()["dmrgo"]["unsorted reduce keys"]; got != 1 {
+		t.Errorf("unsorted reduce keys=%d, want 1", got)
+	}
+
+	// the input is still reduced, one group at a time
+	if len(e.KeyValues) != 4 {
+		t.Errorf("reduced %v, want 4 groups", e.KeyValues)
+	}
+}
+
+func TestForEachInputStdinAmongFiles(t *testing.T) {
+
+	quietStderr(t)
+
+	dir := t.TempDir()
+
+	write := func(name, s string) string {
+		fname := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fname, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fname
+	}
+
+	first := write("first", "one\n")
+	last := write("last", "three\n")
+
+	stdin, err := os.Open(write("stdin", "two\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	oldStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = oldStdin }()
+
+	var mu sync.Mutex
+	var got []string
+
+	n, _ := forEachInput(newRunState(), []string{first, "-", last}, func(index int, r io.Reader) int {
+		b, _ := ioutil.ReadAll(r)
+		mu.Lock()
+		got = append(got, fmt.Sprintf("%d:%s", index, b))
+		mu.Unlock()
+		return 1
+	})
+
+	sort.Strings(got)
+	want := []string{"0:one\n", "1:two\n", "2:three\n"}
+
+	if n != 3 || !reflect.DeepEqual(got, want) {
+		t.Errorf("mapped %d inputs %q, want 3 inputs %q", n, got, want)
+	}
+}
+
+func TestLimit(t *testing.T) {
+
+	var input string
+	for i := 0; i < 100; i++ {
+		input += fmt.Sprintf("word%02d\n", i)
+	}
+
+	setFlag(t, "limit", "3")
+
+	// -limit counts from the start of each input, so splits mustn't each map 3
+	setFlag(t, "split-size", "10")
+
+	_, lines, err := runLocal(t, wordJob{}, []string{input, input[:7*10]}, &RunOptions{Mappers: 4, Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(lines)
+	want := []string{"word00\t2", "word01\t2", "word02\t2"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("with -limit 3 got %q, want %q", lines, want)
+	}
+
+	_, lines, err = runLocal(t, wordJob{}, []string{input}, &RunOptions{Mappers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 3 {
+		t.Errorf("with -limit 3 and a single input which could be split, got %q, want 3 words", lines)
+	}
+}
+
+func TestMapOnlyDoesNotSort(t *testing.T) {
+
+	oldLookPath := lookPath
+	defer func() { lookPath = oldLookPath }()
+
+	var looked []string
+	lookPath = func(file string) (string, error) {
+		looked = append(looked, file)
+		return "", fmt.Errorf("no %s here", file)
+	}
+
+	res, lines, err := runLocal(t, wordJob{}, []string{"b a\n", "c\n"}, &RunOptions{MapOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if looked != nil {
+		t.Errorf("map-only run looked for %q", looked)
+	}
+
+	if len(res.Files) != 0 {
+		t.Errorf("map-only run has partition files %v", res.Files)
+	}
+
+	// map output is written as it was emitted, one output per input
+	sort.Strings(lines)
+	want := []string{"a\t1", "b\t1", "c\t1"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("map-only run wrote %q, want %q", lines, want)
+	}
+}
+
+// lowerJob groups words case-insensitively
+type lowerJob struct {
+	wordJob
+}
+
+func (lowerJob) NormalizeKey(key string) string { return strings.ToLower(key) }
+
+func TestKeyNormalizer(t *testing.T) {
+
+	kvs := []KeyValue{{"BAR", "1"}, {"Foo", "1"}, {"foo", "1"}}
+
+	var e SliceEmitter
+	reduceRecords(lowerJob{}, &sliceRecordReader{kvs}, &e)
+
+	want := []KeyValue{{"bar", "1"}, {"foo", "2"}}
+	if !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced %v, want %v", e.KeyValues, want)
+	}
+
+	// checksums are of the key as written, not as normalized
+	setFlag(t, "checksum", "crc32c")
+
+	res, lines, err := runLocal(t, lowerJob{}, []string{"Foo foo BAR\n"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"bar\t1", "foo\t2"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("with -checksum, reduced %q, want %q", lines, want)
+	}
+	if n := res.Counters["dmrgo"]["checksum failures"]; n != 0 {
+		t.Errorf("with -checksum, %d checksum failures", n)
+	}
+}
+
+func TestReduceEmptyKey(t *testing.T) {
+
+	tests := []struct {
+		kvs  []KeyValue
+		want []KeyValue
+	}{
+		{[]KeyValue{{"", "1"}, {"", "2"}, {"a", "1"}, {"b", "1"}, {"b", "1"}}, []KeyValue{{"", "3"}, {"a", "1"}, {"b", "2"}}},
+		{[]KeyValue{{"", "1"}}, []KeyValue{{"", "1"}}},
+		{[]KeyValue{{"a", "1"}}, []KeyValue{{"a", "1"}}},
+		{nil, nil},
+	}
+
+	for _, tt := range tests {
+		var e SliceEmitter
+		reduceRecords(wordJob{}, &sliceRecordReader{tt.kvs}, &e)
+		if !reflect.DeepEqual(e.KeyValues, tt.want) {
+			t.Errorf("reducing %v gave %v, want %v", tt.kvs, e.KeyValues, tt.want)
+		}
+	}
+
+	// an empty key survives the line format
+	kv, err := ReadKeyValue(bufio.NewReader(strings.NewReader("\t1\n")))
+	if err != nil || kv.Key != "" || kv.Value != "1" {
+		t.Errorf("reading an empty key gave %v, %v", kv, err)
+	}
+}
+
+// joinJob emits each key's values, joined, in the order Reduce saw them
+type joinJob struct {
+	wordJob
+}
+
+func (joinJob) Reduce(key string, values []string, emitter Emitter) {
+	emitter.Emit(key, strings.Join(values, ","))
+}
+
+type sortedJob struct {
+	joinJob
+	SortedValues
+}
+
+func TestSortedValues(t *testing.T) {
+
+	kvs := []KeyValue{{"a", "c"}, {"a", "a"}, {"a", "b"}, {"b", "2"}, {"b", "10"}}
+
+	var e SliceEmitter
+	reduceRecords(sortedJob{}, &sliceRecordReader{kvs}, &e)
+
+	want := []KeyValue{{"a", "a,b,c"}, {"b", "10,2"}}
+	if !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced %v, want %v", e.KeyValues, want)
+	}
+}
+
+// finalJob counts the calls to ReduceFinal
+type finalJob struct {
+	wordJob
+	finals *int32
+}
+
+func (j finalJob) ReduceFinal(emitter Emitter) {
+	atomic.AddInt32(j.finals, 1)
+}
+
+func TestEmptyPartitions(t *testing.T) {
+
+	var finals int32
+	res, lines, err := runLocal(t, finalJob{finals: &finals}, []string{"a a\n"}, &RunOptions{Partitions: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a\t2"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("reduced %q, want %q", lines, want)
+	}
+
+	// every partition is reduced, and has an output file, even with nothing in it
+	if finals != 4 {
+		t.Errorf("ReduceFinal was called %d times, want once per partition", finals)
+	}
+
+	if len(res.Outputs) != 4 {
+		t.Fatalf("outputs=%v, want one per partition", res.Outputs)
+	}
+	for partition, fname := range res.Outputs {
+		if _, err := os.Stat(fname); err != nil {
+			t.Errorf("partition %d: %v", partition, err)
+		}
+	}
+}
+
+// evenJob only emits for keys with an even count
+type evenJob struct {
+	wordJob
+}
+
+func (evenJob) Reduce(key string, values []string, emitter Emitter) {
+	if len(values)%2 == 0 {
+		emitter.Emit(key, strconv.Itoa(len(values)))
+	}
+}
+
+func TestReduceGroupLimit(t *testing.T) {
+
+	setFlag(t, "reduce-group-limit", "2")
+
+	kvs := []KeyValue{{"a", "1"}, {"a", "1"}, {"b", "1"}, {"c", "1"}, {"c", "1"}, {"d", "1"}, {"d", "1"}}
+
+	// groups which emit nothing don't count towards the limit, and the last
+	// group counted is reduced in full
+	var e SliceEmitter
+	reduceRecords(evenJob{}, &sliceRecordReader{kvs}, &e)
+
+	want := []KeyValue{{"a", "2"}, {"c", "2"}}
+	if !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced %v, want %v", e.KeyValues, want)
+	}
+}
+
+func TestKeyValueRoundTrip(t *testing.T) {
+
+	kvs := []KeyValue{{"a", "1"}, {"", "empty key"}, {"key", ""}, {"k", "a value with spaces"}}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, kv := range kvs {
+		WriteKeyValue(w, kv)
+	}
+	w.Flush()
+
+	if want := "a\t1\n\tempty key\nkey\t\nk\ta value with spaces\n"; buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+
+	br := bufio.NewReader(&buf)
+	for _, want := range kvs {
+		kv, err := ReadKeyValue(br)
+		if err != nil || *kv != want {
+			t.Errorf("read %v, %v, want %v", kv, err, want)
+		}
+	}
+	if _, err := ReadKeyValue(br); err != io.EOF {
+		t.Errorf("after the last line got %v, want io.EOF", err)
+	}
+}
+
+func TestMultiByteSeparator(t *testing.T) {
+
+	setFlag(t, "kv-separator", "::")
+
+	kvs := []KeyValue{{"key", "a:b"}, {"key", ":leading"}, {"k", "trailing:"}, {"k", "a::b"}}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, kv := range kvs {
+		WriteKeyValue(w, kv)
+	}
+	w.Flush()
+
+	// the key is split at the first separator, so values may contain it
+	br := bufio.NewReader(&buf)
+	for _, want := range kvs {
+		kv, err := ReadKeyValue(br)
+		if err != nil || *kv != want {
+			t.Errorf("read %v, %v, want %v", kv, err, want)
+		}
+	}
+
+	_, lines, err := runLocal(t, joinJob{}, []string{"x a:b c\n"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a:b::1", "c::1", "x::1"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("with -kv-separator :: reduced %q, want %q", lines, want)
+	}
+}
+
+// fieldJob counts visits by country, city and day, keyed by the number of key
+// fields and the fields joined with slashes
+type fieldJob struct {
+	wordJob
+}
+
+func (fieldJob) Map(key string, value string, emitter Emitter) {
+	f := strings.Fields(value)
+	emitter.Emit(strings.Join(f, optKeyFieldSeparator), "1")
+}
+
+func (fieldJob) ReduceFields(keyFields []string, values []string, emitter Emitter) {
+	emitter.Emit(fmt.Sprintf("%d:%s", len(keyFields), strings.Join(keyFields, "/")), strconv.Itoa(len(values)))
+}
+
+func TestFieldReducer(t *testing.T) {
+
+	inputs := []string{"nz akl mon\nau syd mon\n", "nz akl mon\n"}
+	want := []string{"3:au/syd/mon\t1", "3:nz/akl/mon\t2"}
+
+	// keys survive a run with the default flags
+	_, lines, err := runLocal(t, fieldJob{}, inputs, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(sortedLines(lines), want) {
+		t.Errorf("reduced %q, want %q", sortedLines(lines), want)
+	}
+
+	// and with another separator
+	setFlag(t, "key-field-separator", "|")
+
+	_, lines, err = runLocal(t, fieldJob{}, inputs, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(sortedLines(lines), want) {
+		t.Errorf("reduced %q, want %q", sortedLines(lines), want)
+	}
+
+	var e SliceEmitter
+
+	// a field may be empty
+	reduceRecords(fieldJob{}, &sliceRecordReader{[]KeyValue{{"nz||mon", "1"}}}, &e)
+
+	if want := []KeyValue{{"3:nz//mon", "1"}}; !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced a key with an empty field to %v, want %v", e.KeyValues, want)
+	}
+}
+
+func TestStdout(t *testing.T) {
+
+	quietStderr(t)
+	inTempDir(t)
+	stdout := captureStdout(t)
+
+	if err := ioutil.WriteFile("input", []byte("b a hello user#1 a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, "stdout", "true")
+	setFlag(t, "partitions", "2")
+	flagSet.Parse([]string{"input"})
+	defer flagSet.Parse(nil)
+
+	if status := mapreduce(wordJob{}); status != 0 {
+		t.Fatalf("exit status %d", status)
+	}
+
+	// "a" and "user#1" are in partition 0, "b" and "hello" in partition 1, and
+	// there's no message about where the output is
+	if got, want := stdout(), "a\t2\nuser#1\t1\nb\t1\nhello\t1\n"; got != want {
+		t.Errorf("wrote %q to stdout, want %q", got, want)
+	}
+
+	if files, _ := filepath.Glob("red-out*"); len(files) != 0 {
+		t.Errorf("the output files %q were left behind", files)
+	}
+}
+
+func TestWrappedFieldReducer(t *testing.T) {
+
+	// the wrapper's Reduce is called, not the wrapped job's ReduceFields
+	job := WithReducer(fieldJob{}, func(key string, values []string, emitter Emitter) {
+		emitter.Emit(strings.ToUpper(key), strconv.Itoa(len(values)))
+	})
+
+	var e SliceEmitter
+	reduceRecords(job, &sliceRecordReader{[]KeyValue{{"nz|akl", "1"}, {"nz|akl", "1"}}}, &e)
+
+	if want := []KeyValue{{"NZ|AKL", "2"}}; !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced %v, want %v", e.KeyValues, want)
+	}
+}
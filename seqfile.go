@@ -0,0 +1,406 @@
+package dmrgo
+
+// Reading and writing Hadoop SequenceFiles
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// RecordReader reads key/value records from an input.  Keys and values may
+// contain arbitrary bytes.  At the end of the input, ReadRecord returns io.EOF.
+type RecordReader interface {
+	ReadRecord() (*KeyValue, error)
+}
+
+// SequenceFile constants, from org.apache.hadoop.io.SequenceFile
+const (
+	seqBlockCompressVersion  = 4
+	seqCustomCompressVersion = 5
+	seqMetadataVersion       = 6
+	seqSyncSize              = 16
+	seqSyncEscape            = -1
+)
+
+var seqMagic = []byte("SEQ")
+
+// ErrBlockCompressed is returned when opening a block-compressed SequenceFile, which is not supported
+var ErrBlockCompressed = errors.New("dmrgo: block-compressed SequenceFiles are not supported")
+
+// SequenceFileReader reads records from an uncompressed or record-compressed Hadoop SequenceFile.
+// The records are the raw serialized Writable bytes; decoding them is left to the protocol.
+type SequenceFileReader struct {
+	// Class names of the keys and values, from the file header
+	KeyClass   string
+	ValueClass string
+
+	// Compression codec class name, if the values are compressed
+	Codec string
+
+	// Metadata from the file header
+	Metadata map[string]string
+
+	r          *bufio.Reader
+	version    byte
+	compressed bool
+	sync       [seqSyncSize]byte
+}
+
+// NewSequenceFileReader reads the SequenceFile header from r and returns a reader for its records
+func NewSequenceFileReader(r io.Reader) (*SequenceFileReader, error) {
+
+	sr := &SequenceFileReader{r: bufio.NewReader(r)}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(sr.r, magic[:]); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic[:3], seqMagic) {
+		return nil, errors.New("dmrgo: not a SequenceFile")
+	}
+
+	sr.version = magic[3]
+
+	var err error
+
+	if sr.version < seqBlockCompressVersion {
+		sr.KeyClass, err = sr.readUTF8()
+		if err == nil {
+			sr.ValueClass, err = sr.readUTF8()
+		}
+	} else {
+		sr.KeyClass, err = sr.readText()
+		if err == nil {
+			sr.ValueClass, err = sr.readText()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sr.version > 2 {
+		if sr.compressed, err = sr.readBool(); err != nil {
+			return nil, err
+		}
+	}
+
+	if sr.version >= seqBlockCompressVersion {
+		blockCompressed, err := sr.readBool()
+		if err != nil {
+			return nil, err
+		}
+		if blockCompressed {
+			return nil, ErrBlockCompressed
+		}
+	}
+
+	if sr.compressed {
+		if sr.version >= seqCustomCompressVersion {
+			if sr.Codec, err = sr.readText(); err != nil {
+				return nil, err
+			}
+		} else {
+			sr.Codec = "org.apache.hadoop.io.compress.DefaultCodec"
+		}
+	}
+
+	sr.Metadata = make(map[string]string)
+	if sr.version >= seqMetadataVersion {
+		var n int32
+		if err := binary.Read(sr.r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		for i := int32(0); i < n; i++ {
+			k, err := sr.readText()
+			if err != nil {
+				return nil, err
+			}
+			v, err := sr.readText()
+			if err != nil {
+				return nil, err
+			}
+			sr.Metadata[k] = v
+		}
+	}
+
+	if sr.version > 1 {
+		if _, err := io.ReadFull(sr.r, sr.sync[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return sr, nil
+}
+
+// ReadRecord implements the RecordReader interface
+func (sr *SequenceFileReader) ReadRecord() (*KeyValue, error) {
+
+	var recordLen int32
+	if err := binary.Read(sr.r, binary.BigEndian, &recordLen); err != nil {
+		return nil, err
+	}
+
+	if recordLen == seqSyncEscape {
+		var sync [seqSyncSize]byte
+		if _, err := io.ReadFull(sr.r, sync[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if sync != sr.sync {
+			return nil, errors.New("dmrgo: SequenceFile sync marker mismatch")
+		}
+		if err := binary.Read(sr.r, binary.BigEndian, &recordLen); err != nil {
+			return nil, err
+		}
+	}
+
+	var keyLen int32
+	if err := binary.Read(sr.r, binary.BigEndian, &keyLen); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	if recordLen < 0 || keyLen < 0 || keyLen > recordLen {
+		return nil, fmt.Errorf("dmrgo: bad SequenceFile record lengths %d/%d", recordLen, keyLen)
+	}
+
+	buf := make([]byte, recordLen)
+	if _, err := io.ReadFull(sr.r, buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	key, value := buf[:keyLen], buf[keyLen:]
+
+	if sr.compressed {
+		var err error
+		if value, err = sr.decompress(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &KeyValue{string(key), string(value)}, nil
+}
+
+func (sr *SequenceFileReader) decompress(b []byte) ([]byte, error) {
+
+	var zr io.ReadCloser
+	var err error
+
+	switch sr.Codec {
+	case "org.apache.hadoop.io.compress.DefaultCodec":
+		zr, err = zlib.NewReader(bytes.NewReader(b))
+	case "org.apache.hadoop.io.compress.GzipCodec":
+		zr, err = gzip.NewReader(bytes.NewReader(b))
+	default:
+		return nil, errors.New("dmrgo: unsupported SequenceFile codec " + sr.Codec)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+func (sr *SequenceFileReader) readBool() (bool, error) {
+	b, err := sr.r.ReadByte()
+	return b != 0, err
+}
+
+// readText reads a string serialized as a Hadoop Text: a vint length followed by the bytes
+func (sr *SequenceFileReader) readText() (string, error) {
+	n, err := readVLong(sr.r)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", errors.New("dmrgo: negative SequenceFile string length")
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(sr.r, b)
+	return string(b), err
+}
+
+// readUTF8 reads a string serialized as a Hadoop UTF8: a 2-byte length followed by the bytes
+func (sr *SequenceFileReader) readUTF8() (string, error) {
+	var n uint16
+	if err := binary.Read(sr.r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(sr.r, b)
+	return string(b), err
+}
+
+// readVLong reads a variable-length integer as written by Hadoop's WritableUtils.writeVLong
+func readVLong(r io.ByteReader) (int64, error) {
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	first := int8(b)
+	if first >= -112 {
+		return int64(first), nil
+	}
+
+	negative := first < -120
+
+	var n int
+	if negative {
+		n = -(int(first) + 120)
+	} else {
+		n = -(int(first) + 112)
+	}
+
+	var v int64
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		v = v<<8 | int64(b)
+	}
+
+	if negative {
+		v = ^v
+	}
+
+	return v, nil
+}
+
+// running out of data partway through a record is an error, not a clean end of input
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// how many bytes to write between sync markers, as in Hadoop
+const seqSyncInterval = 100 * seqSyncSize
+
+// SequenceFileEmitter writes key/value pairs as an uncompressed Hadoop SequenceFile.
+// Keys and values are written as the raw serialized Writable bytes; use EncodeText
+// to produce them for the default org.apache.hadoop.io.Text classes.
+type SequenceFileEmitter struct {
+	w         *bufio.Writer
+	sync      [seqSyncSize]byte
+	sinceSync int
+}
+
+// NewSequenceFileEmitter writes a SequenceFile header with the given key and value class names to w.
+// If the class names are empty, org.apache.hadoop.io.Text is used.
+func NewSequenceFileEmitter(w io.Writer, keyClass, valueClass string) (*SequenceFileEmitter, error) {
+
+	if keyClass == "" {
+		keyClass = "org.apache.hadoop.io.Text"
+	}
+
+	if valueClass == "" {
+		valueClass = "org.apache.hadoop.io.Text"
+	}
+
+	e := &SequenceFileEmitter{w: bufio.NewWriter(w)}
+
+	if _, err := rand.Read(e.sync[:]); err != nil {
+		return nil, err
+	}
+
+	e.w.Write(seqMagic)
+	e.w.WriteByte(seqMetadataVersion)
+	e.w.WriteString(EncodeText(keyClass))
+	e.w.WriteString(EncodeText(valueClass))
+	e.w.WriteByte(0)                              // not compressed
+	e.w.WriteByte(0)                              // not block compressed
+	binary.Write(e.w, binary.BigEndian, int32(0)) // no metadata
+	_, err := e.w.Write(e.sync[:])
+
+	return e, err
+}
+
+// Emit implements the Emitter interface
+func (e *SequenceFileEmitter) Emit(key string, value string) {
+
+	if e.sinceSync >= seqSyncInterval {
+		binary.Write(e.w, binary.BigEndian, int32(seqSyncEscape))
+		e.w.Write(e.sync[:])
+		e.sinceSync = 0
+	}
+
+	binary.Write(e.w, binary.BigEndian, int32(len(key)+len(value)))
+	binary.Write(e.w, binary.BigEndian, int32(len(key)))
+	e.w.WriteString(key)
+	e.w.WriteString(value)
+
+	e.sinceSync += 8 + len(key) + len(value)
+}
+
+// Flush implements the Emitter interface
+func (e *SequenceFileEmitter) Flush() {
+	e.w.Flush()
+}
+
+// EncodeText serializes s as a Hadoop Text Writable
+func EncodeText(s string) string {
+	var b bytes.Buffer
+	writeVLong(&b, int64(len(s)))
+	b.WriteString(s)
+	return b.String()
+}
+
+// DecodeText deserializes a Hadoop Text Writable
+func DecodeText(b string) (string, error) {
+	r := strings.NewReader(b)
+	n, err := readVLong(r)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 || n != int64(r.Len()) {
+		return "", errors.New("dmrgo: bad Text length")
+	}
+	return b[len(b)-int(n):], nil
+}
+
+// writeVLong writes a variable-length integer as Hadoop's WritableUtils.writeVLong
+func writeVLong(w io.ByteWriter, i int64) {
+
+	if i >= -112 && i <= 127 {
+		w.WriteByte(byte(i))
+		return
+	}
+
+	n := -112
+	if i < 0 {
+		i = ^i
+		n = -120
+	}
+
+	for tmp := i; tmp != 0; tmp >>= 8 {
+		n--
+	}
+
+	w.WriteByte(byte(n))
+
+	if n < -120 {
+		n = -(n + 120)
+	} else {
+		n = -(n + 112)
+	}
+
+	for idx := n; idx != 0; idx-- {
+		w.WriteByte(byte(i >> uint((idx-1)*8)))
+	}
+}
@@ -0,0 +1,61 @@
+package dmrgo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSequenceFileRoundTrip(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	e, err := NewSequenceFileEmitter(&buf, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// enough records to need sync markers, and values long enough for multi-byte lengths
+	var want []KeyValue
+	for i := 0; i < 200; i++ {
+		want = append(want, KeyValue{fmt.Sprintf("key%d", i), strings.Repeat("v", i*3)})
+	}
+
+	for _, kv := range want {
+		e.Emit(EncodeText(kv.Key), EncodeText(kv.Value))
+	}
+	e.Flush()
+
+	r, err := NewSequenceFileReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.KeyClass != "org.apache.hadoop.io.Text" || r.ValueClass != "org.apache.hadoop.io.Text" {
+		t.Errorf("classes=%q %q, want Text", r.KeyClass, r.ValueClass)
+	}
+
+	for i, kv := range want {
+		rkv, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		k, err := DecodeText(rkv.Key)
+		if err != nil {
+			t.Fatalf("record %d key: %v", i, err)
+		}
+		v, err := DecodeText(rkv.Value)
+		if err != nil {
+			t.Fatalf("record %d value: %v", i, err)
+		}
+		if k != kv.Key || v != kv.Value {
+			t.Fatalf("record %d: got %q %q, want %q %q", i, k, v, kv.Key, kv.Value)
+		}
+	}
+
+	if _, err := r.ReadRecord(); err != io.EOF {
+		t.Errorf("after the last record got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,171 @@
+package dmrgo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingJob counts the calls to Map
+type countingJob struct {
+	wordJob
+	maps *int
+}
+
+func (j countingJob) Map(key string, value string, emitter Emitter) {
+	*j.maps++
+	j.wordJob.Map(key, value, emitter)
+}
+
+func TestMissingSortBinary(t *testing.T) {
+
+	oldLookPath := lookPath
+	defer func() { lookPath = oldLookPath }()
+
+	lookPath = func(file string) (string, error) {
+		return "", fmt.Errorf("exec: %q: executable file not found in $PATH", file)
+	}
+
+	setFlag(t, "sort-bin", "no-such-sort")
+
+	var maps int
+	_, _, err := runLocal(t, countingJob{maps: &maps}, []string{"a b\n"}, nil)
+
+	if err == nil || !strings.Contains(err.Error(), `"no-such-sort"`) || !strings.Contains(err.Error(), "-sort-bin") {
+		t.Errorf("got error %v, want one naming the sort binary and -sort-bin", err)
+	}
+
+	if maps != 0 {
+		t.Errorf("Map was called %d times before the missing sort was noticed", maps)
+	}
+
+	if files, _ := ioutil.ReadDir("."); len(files) != 1 {
+		t.Errorf("the run left files behind: %v", files)
+	}
+}
+
+func TestSortCommand(t *testing.T) {
+
+	cmd := sortCommand("/bin/sort", []string{"-o", "{output}", "{inputs}"}, "out", []string{"in1", "in2"})
+
+	if want := []string{"/bin/sort", "-o", "out", "in1", "in2"}; !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("args=%q, want %q", cmd.Args, want)
+	}
+
+	// the C locale must win over whatever is inherited, so it must come last
+	env := make(map[string]string)
+	for _, kv := range cmd.Env {
+		if i := strings.Index(kv, "="); i != -1 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	if env["LC_ALL"] != "C" || env["LC_COLLATE"] != "C" {
+		t.Errorf("sort runs with LC_ALL=%q LC_COLLATE=%q, want C", env["LC_ALL"], env["LC_COLLATE"])
+	}
+}
+
+func TestSortByteOrder(t *testing.T) {
+
+	// a locale-aware sort would interleave the cases, and split the groups
+	t.Setenv("LC_ALL", "en_US.UTF-8")
+
+	_, lines, err := runLocal(t, wordJob{}, []string{"b B a\n", "A b a B\n"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"A\t1", "B\t2", "a\t2", "b\t2"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("reduced %q, want %q", lines, want)
+	}
+}
+
+// writeScript writes an executable shell script, returning its path
+func writeScript(t *testing.T, name, script string) string {
+	t.Helper()
+
+	fname := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(fname, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestSortTimeout(t *testing.T) {
+
+	slowSort := writeScript(t, "slow-sort", "sleep 10\n")
+
+	setFlag(t, "sort-cmd", slowSort+" {output} {inputs}")
+	setFlag(t, "sort-timeout", "100ms")
+
+	start := time.Now()
+	_, _, err := runLocal(t, wordJob{}, []string{"a b\n"}, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the run took %v, so the sort wasn't killed", d)
+	}
+}
+
+func TestSortCmd(t *testing.T) {
+
+	// the stub takes the output first, and logs each call
+	calls := filepath.Join(t.TempDir(), "calls")
+	stubSort := writeScript(t, "stub-sort", fmt.Sprintf("out=$1; shift; echo sorted >> %s; sort \"$@\" > \"$out\"\n", calls))
+
+	setFlag(t, "sort-cmd", stubSort+" {output} {inputs}")
+
+	_, lines, err := runLocal(t, wordJob{}, []string{"b a b\n", "c a\n"}, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a\t2", "b\t2", "c\t1"}; !reflect.DeepEqual(sortedLines(lines), want) {
+		t.Errorf("reduced %q, want %q", sortedLines(lines), want)
+	}
+
+	b, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "sorted\n"); n != 2 {
+		t.Errorf("the stub sorted %d times, want once per partition", n)
+	}
+}
+
+func TestSortTemplate(t *testing.T) {
+
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"", []string{"sort", "-o", "{output}", "{inputs}"}},
+		{"sort -S 1G -o {output} {inputs}", []string{"sort", "-S", "1G", "-o", "{output}", "{inputs}"}},
+		{"mysort {inputs} {output}", []string{"mysort", "{inputs}", "{output}"}},
+		{"   ", nil},
+		{"sort -o {output}", nil},
+		{"sort {inputs}", nil},
+		{"sort {inputs} {inputs} {output}", nil},
+		{"{inputs} {output}", nil},
+		{"{output} sort {inputs}", nil},
+	}
+
+	for _, tt := range tests {
+		setFlag(t, "sort-cmd", tt.cmd)
+		got, err := sortTemplate()
+
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("sortTemplate(%q)=%q, want an error", tt.cmd, got)
+			}
+		} else if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortTemplate(%q)=%q, %v, want %q", tt.cmd, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package dmrgo
+
+// JSON protocol with keys that sort in their natural order
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// SortableJSONProtocol encodes values as JSON, but encodes numeric keys so
+// that the byte-wise sort of the shuffle puts them in numeric order (so 9 sorts
+// before 10, and negative numbers before positive).  Other keys are encoded as JSON.
+//
+// Numeric keys are written as a type tag followed by 16 hex digits: 'i' for
+// signed integers, 'u' for unsigned integers and 'f' for floats.  Other keys are
+// tagged with 'j'.
+type SortableJSONProtocol struct {
+	JSONProtocol
+}
+
+// MarshalKV implements the StreamProtocol interface
+func (p *SortableJSONProtocol) MarshalKV(key interface{}, value interface{}) *KeyValue {
+	return &KeyValue{sortableKey(key), string(marshalJSON(value))}
+}
+
+// MarshalKey implements the KeyMarshaler interface
+func (p *SortableJSONProtocol) MarshalKey(key interface{}) string {
+	return sortableKey(key)
+}
+
+// UnmarshalKVs implements the StreamProtocol interface
+func (p *SortableJSONProtocol) UnmarshalKVs(key string, values []string, k interface{}, vs interface{}) {
+	unmarshalSortableKey(key, k)
+	p.JSONProtocol.UnmarshalKVs("null", values, nil, vs)
+}
+
+func sortableKey(key interface{}) string {
+
+	v := reflect.ValueOf(key)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// flip the sign bit so negative numbers sort first
+		return fmt.Sprintf("i%016x", uint64(v.Int())^(1<<63))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("u%016x", v.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		bits := math.Float64bits(v.Float())
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return fmt.Sprintf("f%016x", bits)
+	}
+
+	j := marshalJSON(key)
+	return "j" + string(j)
+}
+
+// unmarshalSortableKey decodes key into k, which should be a pointer
+func unmarshalSortableKey(key string, k interface{}) {
+
+	if key == "" {
+		return
+	}
+
+	if key[0] == 'j' {
+		json.Unmarshal([]byte(key[1:]), k)
+		return
+	}
+
+	kv := reflect.ValueOf(k)
+	if kv.Kind() != reflect.Ptr || kv.IsNil() {
+		return
+	}
+	kv = kv.Elem()
+
+	bits, err := strconv.ParseUint(key[1:], 16, 64)
+	if err != nil {
+		return
+	}
+
+	var n interface{}
+
+	switch key[0] {
+	case 'i':
+		n = int64(bits ^ (1 << 63))
+	case 'u':
+		n = bits
+	case 'f':
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		n = math.Float64frombits(bits)
+	default:
+		return
+	}
+
+	nv := reflect.ValueOf(n)
+	if kv.Kind() == reflect.Interface {
+		kv.Set(nv)
+	} else if nv.Type().ConvertibleTo(kv.Type()) {
+		kv.Set(nv.Convert(kv.Type()))
+	}
+}
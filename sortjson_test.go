@@ -0,0 +1,41 @@
+package dmrgo
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestSortableJSONIntegerKeys(t *testing.T) {
+
+	p := new(SortableJSONProtocol)
+
+	// one and two digit keys, which sort wrongly as plain JSON ("10" < "9")
+	ints := []int{9, 10, 1, 99, -5, 0, 42, -10, 2}
+
+	var keys []string
+	byKey := make(map[string]int)
+	for _, n := range ints {
+		kv := p.MarshalKV(n, "v")
+		keys = append(keys, kv.Key)
+		byKey[kv.Key] = n
+
+		var k int
+		var vs []string
+		p.UnmarshalKVs(kv.Key, []string{kv.Value}, &k, &vs)
+		if k != n || len(vs) != 1 || vs[0] != "v" {
+			t.Errorf("round trip of %d gave %d %q", n, k, vs)
+		}
+	}
+
+	// the shuffle sorts the keys byte-wise
+	sort.Strings(keys)
+
+	var got []int
+	for _, k := range keys {
+		got = append(got, byKey[k])
+	}
+
+	if !sort.IntsAreSorted(got) {
+		t.Errorf("byte-wise sorted keys are in the order %v, want numeric order", got)
+	}
+}
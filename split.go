@@ -0,0 +1,106 @@
+package dmrgo
+
+// Splitting a single large input file between several mappers
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// how big a single input file must be before it's split between the mappers (0 to never split)
+var optSplitSize int64
+
+// a byte range of an input file
+type inputSplit struct {
+	start, end int64
+}
+
+// splitInput divides fname into up to n byte ranges, if it's large enough to be worth splitting.
+// Compressed files and stdin can't be split.  Nor are inputs when -limit is
+// given, which counts records from the start of each input.
+func splitInput(fname string, n int) []inputSplit {
+
+	if n <= 1 || optSplitSize <= 0 || optLimit > 0 || fname == "-" || codecFor(filepath.Ext(fname)) != nil {
+		return nil
+	}
+
+	fi, err := os.Stat(fname)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() < optSplitSize {
+		return nil
+	}
+
+	size := fi.Size()
+	splits := make([]inputSplit, n)
+	for i := range splits {
+		splits[i] = inputSplit{size * int64(i) / int64(n), size * int64(i+1) / int64(n)}
+	}
+
+	return splits
+}
+
+// splitReader returns the lines of a file which start within a split.  Each line
+// belongs to the split containing its first byte, so a line straddling the end
+// of the split is read in full, and the partial line at the start is skipped.
+type splitReader struct {
+	f   *os.File
+	br  *bufio.Reader
+	pos int64
+	end int64
+	buf []byte
+}
+
+func openSplit(fname string, s inputSplit) (*splitReader, error) {
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &splitReader{f: f, pos: s.start, end: s.end}
+
+	if s.start > 0 {
+		// back up a byte, so if the split starts exactly at a line we don't skip it
+		if _, err := f.Seek(s.start-1, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.br = bufio.NewReader(f)
+		skipped, err := r.br.ReadSlice('\n')
+		for err == bufio.ErrBufferFull {
+			r.pos += int64(len(skipped))
+			skipped, err = r.br.ReadSlice('\n')
+		}
+		r.pos += int64(len(skipped)) - 1
+	} else {
+		r.br = bufio.NewReader(f)
+	}
+
+	return r, nil
+}
+
+func (r *splitReader) Read(p []byte) (int, error) {
+
+	if len(r.buf) == 0 {
+		if r.pos >= r.end {
+			return 0, io.EOF
+		}
+		line, err := r.br.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return 0, err
+		}
+		r.pos += int64(len(line))
+		r.buf = line
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *splitReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,53 @@
+package dmrgo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitsStraddlingLines(t *testing.T) {
+
+	// lines of varied lengths, including a very long one, so split boundaries
+	// land mid-line, at line starts and inside the long line
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, strings.Repeat(string(rune('a'+i%26)), i%37))
+	}
+	lines[100] = strings.Repeat("x", 10000)
+	content := strings.Join(lines, "\n") + "\n"
+
+	fname := filepath.Join(t.TempDir(), "input")
+	if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, "split-size", "1")
+
+	for _, n := range []int{2, 3, 7, 16, 64} {
+
+		splits := splitInput(fname, n)
+		if len(splits) != n {
+			t.Fatalf("split into %d, want %d", len(splits), n)
+		}
+
+		var got string
+		for _, s := range splits {
+			r, err := openSplit(fname, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got += string(b)
+		}
+
+		if got != content {
+			t.Errorf("%d splits: the splits' lines don't add up to the file's", n)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package dmrgo
+
+// Keeping the values for a key in the order they were emitted
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// StableOrderer can be implemented by jobs which want the values for each key
+// in the order the mappers emitted them, rather than the order the shuffle's
+// sort leaves them in.  The runner prefixes each map output value with a
+// sequence number, so the sort orders the values for a key by when they were
+// emitted, and strips it again before calling Reduce.  Values emitted by
+// concurrent mappers are ordered by when they were emitted, across all mappers.
+// Values aren't merged in the mapper, and -combine-on-merge can't be used.
+// Jobs can embed StableValues.
+type StableOrderer interface {
+	StableOrder() bool
+}
+
+// StableValues can be embedded in a job to have its values reduced in emit order
+type StableValues struct{}
+
+// StableOrder implements the StableOrderer interface
+func (StableValues) StableOrder() bool { return true }
+
+// the width of the hex sequence number, and the separator after it
+const stableSeqLen = 16 + 1
+
+// the next sequence number, shared by all the mappers in this process
+var stableSeq uint64
+
+// stableEmitter prefixes values with a fixed-width sequence number, which
+// sorts byte-wise in emit order
+type stableEmitter struct {
+	Emitter
+}
+
+func (e stableEmitter) Emit(key string, value string) {
+	e.Emitter.Emit(key, sequenced(value))
+}
+
+// EmitAll implements the BroadcastEmitter interface, if the wrapped emitter does
+func (e stableEmitter) EmitAll(key string, value string) {
+	if b, ok := e.Emitter.(BroadcastEmitter); ok {
+		b.EmitAll(key, sequenced(value))
+	} else {
+		e.Emitter.Emit(key, sequenced(value))
+	}
+}
+
+// sequenced prefixes value with the next sequence number
+func sequenced(value string) string {
+	seq := atomic.AddUint64(&stableSeq, 1)
+	return fmt.Sprintf("%016x:%s", seq, value)
+}
+
+// isStable reports whether the job wants its values in emit order
+func isStable(mrjob MapReduceJob) bool {
+	s, ok := mrjob.(StableOrderer)
+	return ok && s.StableOrder()
+}
+
+// mapperStable wraps the map emitter to add sequence numbers, if the job wants them
+func mapperStable(mrjob MapReduceJob, emitter Emitter) Emitter {
+	if isStable(mrjob) {
+		return stableEmitter{emitter}
+	}
+	return emitter
+}
+
+// stripSequence removes the sequence numbers added by stableEmitter.  Values
+// without one, such as those from a mapper which didn't add them, are left alone.
+func stripSequence(values []string) {
+	for i, v := range values {
+		if hasSequence(v) {
+			values[i] = v[stableSeqLen:]
+		}
+	}
+}
+
+// hasSequence reports whether v starts with a sequence number as stableEmitter writes them
+func hasSequence(v string) bool {
+
+	if len(v) < stableSeqLen || v[stableSeqLen-1] != ':' {
+		return false
+	}
+
+	for _, c := range v[:stableSeqLen-1] {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
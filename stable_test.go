@@ -0,0 +1,78 @@
+package dmrgo
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stableJob emits each word of its input under its first letter, and joins the
+// words in the order they were emitted
+type stableJob struct {
+	joinJob
+	StableValues
+}
+
+func (stableJob) Map(key string, value string, emitter Emitter) {
+	for _, w := range strings.Fields(value) {
+		emitter.Emit(w[:1], w)
+	}
+}
+
+func TestStableValues(t *testing.T) {
+
+	// the values would sort otherwise; one of them looks like it already has a sequence number
+	input := "az ay\nbz 0123456789abcdef:x ay2\nby\n"
+
+	setFlag(t, "checksum", "crc32c")
+	setFlag(t, "verify-partitions", "true")
+	resetCounters()
+
+	_, lines, err := runLocal(t, stableJob{}, []string{input}, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"0\t0123456789abcdef:x", "a\taz,ay,ay2", "b\tbz,by"}
+	if got := sortedLines(lines); !reflect.DeepEqual(got, want) {
+		t.Errorf("reduced %q, want %q", got, want)
+	}
+
+	totals := counterTotals()["dmrgo"]
+	if totals["checksum failures"] != 0 || totals["partition mismatches"] != 0 {
+		t.Errorf("checksum failures=%d, partition mismatches=%d, want none", totals["checksum failures"], totals["partition mismatches"])
+	}
+}
+
+func TestStableEmitterEmitAll(t *testing.T) {
+
+	// broadcast pairs get the same sequence number in every partition
+	pe := newPartitionEmitter(nil, 3, filepath.Join(t.TempDir(), "map-out"))
+	stableEmitter{pe}.EmitAll("k", "v")
+	pe.Close()
+
+	contents := partitionContents(t, pe)
+	for partition, got := range contents {
+		if got != contents[0] || !strings.HasPrefix(got, "k\t") || !strings.HasSuffix(got, ":v\n") || len(got) != len("k\t")+stableSeqLen+len("v\n") {
+			t.Errorf("partition %d has %q, want the sequenced pair %q", partition, got, contents[0])
+		}
+	}
+
+	// values which only look like they're sequenced are left alone
+	values := []string{"2024-01-01T00:00:00", "0123456789ABCDEF:x", "000000000000002a:x"}
+	stripSequence(values)
+	if want := []string{"2024-01-01T00:00:00", "0123456789ABCDEF:x", "x"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("stripped to %q, want %q", values, want)
+	}
+
+	// emitters which can't broadcast get the pair once
+	var e SliceEmitter
+	stableEmitter{&e}.EmitAll("k", "v")
+
+	values = []string{e.KeyValues[0].Value}
+	stripSequence(values)
+	if len(e.KeyValues) != 1 || values[0] != "v" {
+		t.Errorf("EmitAll to a SliceEmitter gave %v", e.KeyValues)
+	}
+}
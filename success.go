@@ -0,0 +1,44 @@
+package dmrgo
+
+// Marking the output of a successful run
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"os"
+)
+
+// write a marker file once all the output of a standalone run has been committed
+var optSuccessMarker bool
+
+// the name of the marker file, as Hadoop uses
+const successMarker = "_SUCCESS"
+
+// removeSuccessMarker removes the marker left by an earlier run, so the output
+// isn't marked complete while this run replaces it
+func removeSuccessMarker() {
+	if !optSuccessMarker {
+		return
+	}
+	if err := os.Remove(successMarker); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, "err removing success marker: ", err)
+	}
+}
+
+// writeSuccessMarker writes the empty marker file, unless any part of the run
+// failed.  With -stdout the output files are gone, so there's nothing to mark.
+func writeSuccessMarker(run *runState) {
+
+	if !optSuccessMarker || optStdout || len(run.errors()) > 0 {
+		return
+	}
+
+	f, err := os.Create(successMarker)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "err writing success marker: ", err)
+	}
+}
@@ -0,0 +1,42 @@
+package dmrgo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSuccessMarker(t *testing.T) {
+
+	_, _, err := runLocal(t, wordJob{}, []string{"a b\n"}, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(successMarker); err != nil {
+		t.Fatalf("after a successful run: %v", err)
+	}
+
+	// a failed run in the same directory removes the earlier run's marker
+	failingSort := writeScript(t, "failing-sort", "exit 1\n")
+	setFlag(t, "sort-cmd", failingSort+" {output} {inputs}")
+
+	if _, err := RunLocalFiles(wordJob{}, []string{"input0"}, &RunOptions{Partitions: 2}); err == nil {
+		t.Fatalf("the run with a failing sort succeeded")
+	}
+
+	if _, err := os.Stat(successMarker); !os.IsNotExist(err) {
+		t.Errorf("after a failed run, the marker's stat gave %v, want it gone", err)
+	}
+
+	// and it can be turned off
+	setFlag(t, "sort-cmd", "")
+	setFlag(t, "success-marker", "false")
+
+	if _, err := RunLocalFiles(wordJob{}, []string{"input0"}, &RunOptions{Partitions: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(successMarker); !os.IsNotExist(err) {
+		t.Errorf("with -success-marker=false, the marker's stat gave %v, want no marker", err)
+	}
+}
@@ -0,0 +1,43 @@
+package dmrgo
+
+// Limiting the disk space used by intermediate files
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// the most bytes of intermediate files the standalone map/reduce may write (0 for no limit)
+var optMaxTempBytes int64
+
+// budgetWriter counts the bytes written to an intermediate file against
+// -max-temp-bytes, across all the run's partitions.  Writes are counted as the
+// buffers in front of it are flushed, so the limit may be overshot by up to a
+// buffer per partition.  Once the run is over budget, writes fail, and the run
+// is stopped; its intermediate files are removed as for -fail-fast.
+type budgetWriter struct {
+	w   io.Writer
+	run *runState
+}
+
+func (b budgetWriter) Write(p []byte) (int, error) {
+
+	total := atomic.AddInt64(&b.run.tempBytes, int64(len(p)))
+	if total <= optMaxTempBytes {
+		return b.w.Write(p)
+	}
+
+	err := fmt.Errorf("intermediate files exceeded -max-temp-bytes (%d bytes)", optMaxTempBytes)
+
+	// only the write which went over the budget reports it
+	if total-int64(len(p)) <= optMaxTempBytes {
+		fmt.Fprintln(os.Stderr, "err writing intermediate file: ", err)
+		b.run.stop(err)
+	}
+
+	return 0, err
+}
@@ -0,0 +1,38 @@
+package dmrgo
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMaxTempBytes(t *testing.T) {
+
+	input := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 2000)
+
+	setFlag(t, "max-temp-bytes", "1024")
+
+	_, _, err := runLocal(t, wordJob{}, []string{input}, &RunOptions{Partitions: 2})
+	if err == nil || !strings.Contains(err.Error(), "max-temp-bytes") {
+		t.Fatalf("run over budget gave err=%v, want a -max-temp-bytes error", err)
+	}
+
+	leftover, _ := filepath.Glob("tmp-map-out-*")
+	if len(leftover) != 0 {
+		t.Errorf("intermediate files left behind: %q", leftover)
+	}
+
+	// the next run in the process starts with a fresh budget
+	setFlag(t, "max-temp-bytes", "1000000")
+
+	_, lines, err := runLocal(t, wordJob{}, []string{input}, &RunOptions{Partitions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"brown\t2000", "dog\t2000", "fox\t2000", "jumps\t2000", "lazy\t2000", "over\t2000", "quick\t2000", "the\t4000"}
+	if got := sortedLines(lines); !reflect.DeepEqual(got, want) {
+		t.Errorf("reduced %q, want %q", got, want)
+	}
+}
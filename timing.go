@@ -0,0 +1,143 @@
+package dmrgo
+
+// Timing of the map/reduce phases
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// should we report how long each phase took
+var optTiming bool
+
+// report Reduce calls which take longer than this (0 to not time them)
+var optSlowReduce time.Duration
+
+// how many of the slowest keys to report at the end of a reduce
+const slowReduceKeys = 10
+
+type slowKey struct {
+	key string
+	d   time.Duration
+}
+
+// reduceTimer tracks the keys whose Reduce calls are slower than -slow-reduce
+type reduceTimer struct {
+	slowest *TopN[slowKey]
+}
+
+// newReduceTimer returns a reduceTimer, or nil if -slow-reduce isn't set.  The methods do nothing on nil.
+func newReduceTimer() *reduceTimer {
+	if optSlowReduce <= 0 {
+		return nil
+	}
+	return &reduceTimer{NewTopN[slowKey](slowReduceKeys)}
+}
+
+// done records the Reduce call for key, which started at start
+func (t *reduceTimer) done(key string, start time.Time) {
+
+	if t == nil {
+		return
+	}
+
+	d := time.Since(start)
+	if d < optSlowReduce {
+		return
+	}
+
+	IncrCounter("dmrgo", "slow reduce keys", 1)
+	t.slowest.Add(slowKey{key, d}, float64(d))
+}
+
+// report writes the slowest keys to stderr
+func (t *reduceTimer) report() {
+
+	if t == nil {
+		return
+	}
+
+	for _, s := range t.slowest.Results() {
+		fmt.Fprintf(os.Stderr, "slow reduce: key %q took %v\n", s.key, s.d)
+	}
+}
+
+// phaseTimer accumulates the time spent in a phase, possibly across several goroutines
+type phaseTimer struct {
+	nanos int64
+}
+
+func (t *phaseTimer) add(start time.Time) {
+	atomic.AddInt64(&t.nanos, int64(time.Since(start)))
+}
+
+func (t *phaseTimer) duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.nanos))
+}
+
+// phaseSpan records the earliest start and the latest end of a phase's work,
+// possibly across several goroutines
+type phaseSpan struct {
+	mu         sync.Mutex
+	start, end time.Time
+}
+
+func (s *phaseSpan) add(start, end time.Time) {
+	s.mu.Lock()
+	if s.start.IsZero() || start.Before(s.start) {
+		s.start = start
+	}
+	if end.After(s.end) {
+		s.end = end
+	}
+	s.mu.Unlock()
+}
+
+// PhaseTimes are when the map and reduce phases of a run started and ended,
+// relative to the start of the run, and for how long they overlapped.  A
+// reducer starts on a partition only once all the mappers have finished, so for
+// now Overlap is always zero; the gap between MapEnd and ReduceStart is the
+// time spent waiting between the phases.
+type PhaseTimes struct {
+	MapStart    time.Duration
+	MapEnd      time.Duration
+	ReduceStart time.Duration
+	ReduceEnd   time.Duration
+	Overlap     time.Duration
+}
+
+// newPhaseTimes returns the phase times for a run which started at start, and
+// mapped until mapEnd
+func newPhaseTimes(start time.Time, mapEnd time.Time, reduce *phaseSpan) PhaseTimes {
+
+	p := PhaseTimes{MapEnd: mapEnd.Sub(start)}
+
+	if reduce.start.IsZero() {
+		// nothing was reduced
+		return p
+	}
+
+	p.ReduceStart = reduce.start.Sub(start)
+	p.ReduceEnd = reduce.end.Sub(start)
+
+	if p.ReduceStart < p.MapEnd {
+		p.Overlap = p.MapEnd - p.ReduceStart
+		if p.ReduceEnd < p.MapEnd {
+			p.Overlap = p.ReduceEnd - p.ReduceStart
+		}
+	}
+
+	return p
+}
+
+// reportTiming emits the time spent in a phase as a counter, if -timing was given
+func reportTiming(phase string, d time.Duration) {
+	if optTiming {
+		IncrCounter("dmrgo", phase+" ms", int(d/time.Millisecond))
+	}
+}
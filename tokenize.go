@@ -0,0 +1,55 @@
+package dmrgo
+
+// Splitting lines into tokens
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize splits s into lower-cased words: runs of Unicode letters and numbers.
+// Everything else separates words.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// TokenizeMapper maps each line to its tokens, emitting (token, 1) for each.
+// Embed it in a job to get the Map and MapFinal methods, and add a Reduce.
+type TokenizeMapper struct {
+	// Tokenizer splits a line into tokens.  If nil, Tokenize is used.
+	Tokenizer func(string) []string
+
+	// Protocol marshals the emitted pairs.  If nil, the token is emitted as
+	// is, with the value "1".
+	Protocol StreamProtocol
+}
+
+// NewTokenizeMapper returns a TokenizeMapper using the given tokenizer and protocol
+func NewTokenizeMapper(tokenizer func(string) []string, proto StreamProtocol) *TokenizeMapper {
+	return &TokenizeMapper{Tokenizer: tokenizer, Protocol: proto}
+}
+
+// Map implements the MapReduceJob interface
+func (m *TokenizeMapper) Map(key string, value string, emitter Emitter) {
+
+	tokenize := m.Tokenizer
+	if tokenize == nil {
+		tokenize = Tokenize
+	}
+
+	for _, token := range tokenize(value) {
+		if m.Protocol == nil {
+			emitter.Emit(token, "1")
+			continue
+		}
+		kv := m.Protocol.MarshalKV(token, 1)
+		emitter.Emit(kv.Key, kv.Value)
+	}
+}
+
+// MapFinal implements the MapReduceJob interface
+func (m *TokenizeMapper) MapFinal(emitter Emitter) {}
@@ -0,0 +1,80 @@
+package dmrgo
+
+// Bounded top-N aggregation
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"container/heap"
+	"sort"
+)
+
+type topNItem[T any] struct {
+	item  T
+	score float64
+}
+
+// min-heap on score, so the lowest scoring item is always at the top
+type topNHeap[T any] []topNItem[T]
+
+func (h topNHeap[T]) Len() int            { return len(h) }
+func (h topNHeap[T]) Less(i, j int) bool  { return h[i].score < h[j].score }
+func (h topNHeap[T]) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topNHeap[T]) Push(x interface{}) { *h = append(*h, x.(topNItem[T])) }
+func (h *topNHeap[T]) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// TopN keeps the N highest scoring items it has been given.
+// Only N items are held in memory, regardless of how many are added.
+// TopN is not safe for concurrent use.
+type TopN[T any] struct {
+	n int
+	h topNHeap[T]
+}
+
+// NewTopN returns a TopN which keeps the n highest scoring items
+func NewTopN[T any](n int) *TopN[T] {
+	return &TopN[T]{n: n}
+}
+
+// Add offers an item with the given score
+func (t *TopN[T]) Add(item T, score float64) {
+
+	if t.n <= 0 {
+		return
+	}
+
+	if len(t.h) < t.n {
+		heap.Push(&t.h, topNItem[T]{item, score})
+		return
+	}
+
+	// not better than the worst item we're keeping
+	if score <= t.h[0].score {
+		return
+	}
+
+	t.h[0] = topNItem[T]{item, score}
+	heap.Fix(&t.h, 0)
+}
+
+// Results returns the items kept, highest score first
+func (t *TopN[T]) Results() []T {
+
+	items := make([]topNItem[T], len(t.h))
+	copy(items, t.h)
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].score > items[j].score })
+
+	r := make([]T, len(items))
+	for i, it := range items {
+		r[i] = it.item
+	}
+
+	return r
+}
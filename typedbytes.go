@@ -0,0 +1,482 @@
+package dmrgo
+
+// A protocol for Hadoop's typed bytes format
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// the typed bytes type codes
+const (
+	tbBytes  = 0
+	tbByte   = 1
+	tbBool   = 2
+	tbInt    = 3
+	tbLong   = 4
+	tbFloat  = 5
+	tbDouble = 6
+	tbString = 7
+	tbVector = 8
+	tbList   = 9
+	tbMap    = 10
+
+	// ends a list
+	tbListEnd = 255
+)
+
+var errTypedBytes = errors.New("dmrgo: bad typed bytes")
+
+// TypedBytesProtocol encodes keys and values in Hadoop's typed bytes format,
+// for exchanging typed data with streaming jobs in other languages.  As the
+// line format can't carry binary data, each key and value is base64 encoded.
+//
+// Go values are written as: []byte as bytes, int8 and uint8 as byte, bool as
+// bool, int16 and int32 as int, other integers as long, float32 as float,
+// float64 as double, string as string, other slices and arrays as list, and
+// maps and structs (by field name) as map.  Pointers are written as what they
+// point to, and nil as empty bytes.  When reading, vectors are read as lists,
+// and values are converted to the type being read into where they can be;
+// into an interface{}, they are read as the Go types they would be written from,
+// with lists as []interface{}, maps as map[interface{}]interface{}, and bytes
+// map keys as strings.
+type TypedBytesProtocol struct {
+	// empty -- just a type
+}
+
+// MarshalKV implements the StreamProtocol interface
+func (p *TypedBytesProtocol) MarshalKV(key interface{}, value interface{}) *KeyValue {
+	return &KeyValue{p.MarshalKey(key), encodeTypedBytes(value)}
+}
+
+// MarshalKey implements the KeyMarshaler interface
+func (p *TypedBytesProtocol) MarshalKey(key interface{}) string {
+	return encodeTypedBytes(key)
+}
+
+// UnmarshalKVs implements the StreamProtocol interface
+func (p *TypedBytesProtocol) UnmarshalKVs(key string, values []string, k interface{}, vs interface{}) {
+
+	if kv := reflect.ValueOf(k); kv.Kind() == reflect.Ptr && !kv.IsNil() {
+		if d, err := decodeTypedBytes(key); err == nil {
+			setTypedBytes(kv.Elem(), d)
+		}
+	}
+
+	vsPtrValue := reflect.ValueOf(vs)
+	vsType := reflect.TypeOf(vs).Elem()
+
+	v := reflect.MakeSlice(vsType, len(values), len(values))
+
+	for i, s := range values {
+		d, err := decodeTypedBytes(s)
+		if err != nil {
+			// skip, for now
+			continue
+		}
+		setTypedBytes(v.Index(i), d)
+	}
+
+	vsPtrValue.Elem().Set(v)
+}
+
+func encodeTypedBytes(v interface{}) string {
+	var buf bytes.Buffer
+	writeTypedBytes(&buf, reflect.ValueOf(v))
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func writeTypedBytes(buf *bytes.Buffer, v reflect.Value) {
+
+	var b [8]byte
+
+	writeLen := func(n int) {
+		binary.BigEndian.PutUint32(b[:4], uint32(n))
+		buf.Write(b[:4])
+	}
+
+	switch v.Kind() {
+
+	case reflect.Invalid:
+		buf.WriteByte(tbBytes)
+		writeLen(0)
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			writeTypedBytes(buf, reflect.Value{})
+			return
+		}
+		writeTypedBytes(buf, v.Elem())
+
+	case reflect.Bool:
+		buf.WriteByte(tbBool)
+		if v.Bool() {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+
+	case reflect.Int8:
+		buf.WriteByte(tbByte)
+		buf.WriteByte(byte(v.Int()))
+
+	case reflect.Uint8:
+		buf.WriteByte(tbByte)
+		buf.WriteByte(byte(v.Uint()))
+
+	case reflect.Int16, reflect.Int32:
+		buf.WriteByte(tbInt)
+		binary.BigEndian.PutUint32(b[:4], uint32(v.Int()))
+		buf.Write(b[:4])
+
+	case reflect.Int, reflect.Int64:
+		buf.WriteByte(tbLong)
+		binary.BigEndian.PutUint64(b[:], uint64(v.Int()))
+		buf.Write(b[:])
+
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.WriteByte(tbLong)
+		binary.BigEndian.PutUint64(b[:], v.Uint())
+		buf.Write(b[:])
+
+	case reflect.Float32:
+		buf.WriteByte(tbFloat)
+		binary.BigEndian.PutUint32(b[:4], math.Float32bits(float32(v.Float())))
+		buf.Write(b[:4])
+
+	case reflect.Float64:
+		buf.WriteByte(tbDouble)
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v.Float()))
+		buf.Write(b[:])
+
+	case reflect.String:
+		buf.WriteByte(tbString)
+		writeLen(v.Len())
+		buf.WriteString(v.String())
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf.WriteByte(tbBytes)
+			writeLen(v.Len())
+			for i := 0; i < v.Len(); i++ {
+				buf.WriteByte(byte(v.Index(i).Uint()))
+			}
+			return
+		}
+		buf.WriteByte(tbList)
+		for i := 0; i < v.Len(); i++ {
+			writeTypedBytes(buf, v.Index(i))
+		}
+		buf.WriteByte(tbListEnd)
+
+	case reflect.Map:
+		// sort the entries by their encoding, so equal maps encode the same
+		type entry struct{ k, v []byte }
+		entries := make([]entry, 0, v.Len())
+		for _, mk := range v.MapKeys() {
+			var kb, vb bytes.Buffer
+			writeTypedBytes(&kb, mk)
+			writeTypedBytes(&vb, v.MapIndex(mk))
+			entries = append(entries, entry{kb.Bytes(), vb.Bytes()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].k, entries[j].k) < 0 })
+		buf.WriteByte(tbMap)
+		writeLen(len(entries))
+		for _, e := range entries {
+			buf.Write(e.k)
+			buf.Write(e.v)
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		var fields []int
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				fields = append(fields, i)
+			}
+		}
+		buf.WriteByte(tbMap)
+		writeLen(len(fields))
+		for _, i := range fields {
+			writeTypedBytes(buf, reflect.ValueOf(t.Field(i).Name))
+			writeTypedBytes(buf, v.Field(i))
+		}
+
+	default:
+		// channels, functions and the like can't be written
+		writeTypedBytes(buf, reflect.Value{})
+	}
+}
+
+func decodeTypedBytes(s string) (interface{}, error) {
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(b)
+
+	d, err := readTypedBytes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Len() != 0 {
+		return nil, errTypedBytes
+	}
+
+	return d, nil
+}
+
+// readTypedBytes reads a value, as the Go type it would be written from
+func readTypedBytes(r *bytes.Reader) (interface{}, error) {
+
+	code, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return readTypedBytesValue(r, code)
+}
+
+func readTypedBytesValue(r *bytes.Reader, code byte) (interface{}, error) {
+
+	var b [8]byte
+
+	readN := func(n int) ([]byte, error) {
+		if n < 0 || n > r.Len() {
+			return nil, errTypedBytes
+		}
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+
+	readLen := func() (int, error) {
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		return int(int32(binary.BigEndian.Uint32(b[:4]))), nil
+	}
+
+	switch code {
+
+	case tbBytes:
+		n, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		return readN(n)
+
+	case tbByte:
+		c, err := r.ReadByte()
+		return c, unexpectedEOF(err)
+
+	case tbBool:
+		c, err := r.ReadByte()
+		return c != 0, unexpectedEOF(err)
+
+	case tbInt:
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return int32(binary.BigEndian.Uint32(b[:4])), nil
+
+	case tbLong:
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return int64(binary.BigEndian.Uint64(b[:])), nil
+
+	case tbFloat:
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b[:4])), nil
+
+	case tbDouble:
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b[:])), nil
+
+	case tbString:
+		n, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		s, err := readN(n)
+		return string(s), err
+
+	case tbVector:
+		n, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n > r.Len() {
+			return nil, errTypedBytes
+		}
+		l := make([]interface{}, n)
+		for i := range l {
+			if l[i], err = readTypedBytes(r); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+
+	case tbList:
+		var l []interface{}
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			if c == tbListEnd {
+				return l, nil
+			}
+			e, err := readTypedBytesValue(r, c)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, e)
+		}
+
+	case tbMap:
+		n, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n > r.Len() {
+			return nil, errTypedBytes
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := readTypedBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := readTypedBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			if b, ok := k.([]byte); ok {
+				k = string(b)
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				// lists and maps can't be Go map keys
+				return nil, errTypedBytes
+			}
+			m[k] = v
+		}
+		return m, nil
+	}
+
+	return nil, errTypedBytes
+}
+
+// setTypedBytes stores the decoded value d in v, converting it to v's type
+// where it can.  Values which can't be converted are left alone.
+func setTypedBytes(v reflect.Value, d interface{}) {
+
+	if d == nil {
+		return
+	}
+
+	dv := reflect.ValueOf(d)
+
+	switch v.Kind() {
+
+	case reflect.Interface:
+		if dv.Type().AssignableTo(v.Type()) {
+			v.Set(dv)
+		}
+
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		setTypedBytes(p.Elem(), d)
+		v.Set(p)
+
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if dv.Type().ConvertibleTo(v.Type()) && dv.Kind() != reflect.Slice && dv.Kind() != reflect.String {
+			v.Set(dv.Convert(v.Type()))
+		}
+
+	case reflect.String:
+		switch d := d.(type) {
+		case string:
+			v.SetString(d)
+		case []byte:
+			v.SetString(string(d))
+		}
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			switch d := d.(type) {
+			case []byte:
+				v.SetBytes(d)
+			case string:
+				v.SetBytes([]byte(d))
+			}
+			return
+		}
+		l, ok := d.([]interface{})
+		if !ok {
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), len(l), len(l))
+		for i, e := range l {
+			setTypedBytes(s.Index(i), e)
+		}
+		v.Set(s)
+
+	case reflect.Array:
+		l, ok := d.([]interface{})
+		if !ok {
+			return
+		}
+		for i := 0; i < v.Len() && i < len(l); i++ {
+			setTypedBytes(v.Index(i), l[i])
+		}
+
+	case reflect.Map:
+		m, ok := d.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		mv := reflect.MakeMapWithSize(v.Type(), len(m))
+		for k, e := range m {
+			kv := reflect.New(v.Type().Key()).Elem()
+			setTypedBytes(kv, k)
+			ev := reflect.New(v.Type().Elem()).Elem()
+			setTypedBytes(ev, e)
+			mv.SetMapIndex(kv, ev)
+		}
+		v.Set(mv)
+
+	case reflect.Struct:
+		m, ok := d.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if e, ok := m[t.Field(i).Name]; ok {
+				setTypedBytes(v.Field(i), e)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package dmrgo
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+type tbRecord struct {
+	Name  string
+	Count int
+}
+
+func TestTypedBytesRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		value   interface{}
+		code    byte
+		decoded interface{}
+	}{
+		{[]byte("\x00\xff"), tbBytes, []byte("\x00\xff")},
+		{uint8(200), tbByte, uint8(200)},
+		{true, tbBool, true},
+		{int32(-7), tbInt, int32(-7)},
+		{int64(1) << 40, tbLong, int64(1) << 40},
+		{float32(0.5), tbFloat, float32(0.5)},
+		{-2.25, tbDouble, -2.25},
+		{"gopher\t\n", tbString, "gopher\t\n"},
+		{[]string{"a", "b"}, tbList, []interface{}{"a", "b"}},
+		{map[string]int64{"x": 1}, tbMap, map[interface{}]interface{}{"x": int64(1)}},
+		{tbRecord{"gopher", 3}, tbMap, map[interface{}]interface{}{"Name": "gopher", "Count": int64(3)}},
+	}
+
+	p := new(TypedBytesProtocol)
+
+	for _, tt := range tests {
+
+		s := encodeTypedBytes(tt.value)
+
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 || b[0] != tt.code {
+			t.Errorf("%#v encoded as %q, want type code %d", tt.value, b, tt.code)
+			continue
+		}
+
+		d, err := decodeTypedBytes(s)
+		if err != nil || !reflect.DeepEqual(d, tt.decoded) {
+			t.Errorf("%#v decoded as %#v, %v, want %#v", tt.value, d, err, tt.decoded)
+		}
+
+		// read back into its own type, through the protocol
+		kv := p.MarshalKV("key", tt.value)
+
+		var k string
+		vs := reflect.New(reflect.SliceOf(reflect.TypeOf(tt.value)))
+		p.UnmarshalKVs(kv.Key, []string{kv.Value}, &k, vs.Interface())
+
+		if k != "key" || vs.Elem().Len() != 1 || !reflect.DeepEqual(vs.Elem().Index(0).Interface(), tt.value) {
+			t.Errorf("%#v round tripped to %q %#v", tt.value, k, vs.Elem().Interface())
+		}
+	}
+
+	// vectors, which other languages write, are read as lists
+	vector := base64.StdEncoding.EncodeToString([]byte("\x08\x00\x00\x00\x02\x01\x05\x02\x01"))
+	if d, err := decodeTypedBytes(vector); err != nil || !reflect.DeepEqual(d, []interface{}{uint8(5), true}) {
+		t.Errorf("vector decoded as %#v, %v", d, err)
+	}
+
+	// nil is empty bytes
+	if d, err := decodeTypedBytes(encodeTypedBytes(nil)); err != nil || !reflect.DeepEqual(d, []byte{}) {
+		t.Errorf("nil decoded as %#v, %v, want empty bytes", d, err)
+	}
+
+	for _, bad := range []string{"", "\x07\x00\x00\x00\x09short", "\x0c", "\x01\x05trailing", "\x09\x01\x05"} {
+		if d, err := decodeTypedBytes(base64.StdEncoding.EncodeToString([]byte(bad))); err == nil {
+			t.Errorf("decoding %q gave %#v, want an error", bad, d)
+		}
+	}
+}
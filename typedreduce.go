@@ -0,0 +1,73 @@
+package dmrgo
+
+// Reducing values unmarshaled to their own types
+// Copyright (c) 2011 Damian Gryski <damian@gryski.com>
+// License: GPLv3 or, at your option, any later version
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// TypedReduce unmarshals the key and values with a protocol, and passes them
+// to a reduce function taking them as their own types.  Embed it in a job (or
+// call its Reduce from the job's) to get a Reduce without the unmarshaling
+// boilerplate:
+//
+//	type Counter struct {
+//		dmrgo.TypedReduce[string, int]
+//		...
+//	}
+//
+//	c := &Counter{}
+//	c.Fn = c.sum // func (c *Counter) sum(word string, counts []int, emitter dmrgo.Emitter)
+//
+// The protocol is chosen on the first call to Reduce, and mustn't be changed
+// after that.  A TypedReduce mustn't be copied once Reduce has been called.
+type TypedReduce[K, V any] struct {
+	// Protocol unmarshals the key and values.  If nil, the intermediate
+	// protocol from JobProtocols is used.
+	Protocol StreamProtocol
+
+	// Fn is called for each key with its unmarshaled values
+	Fn func(key K, values []V, emitter Emitter)
+
+	// the protocol in use, chosen once as the concurrent reducers may share us
+	once  sync.Once
+	proto StreamProtocol
+}
+
+// resolve chooses the protocol, reporting it if JobProtocols fails
+func (t *TypedReduce[K, V]) resolve() {
+
+	if t.Protocol != nil {
+		t.proto = t.Protocol
+		return
+	}
+
+	ps, err := JobProtocols()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "err choosing the protocol for TypedReduce: ", err)
+		return
+	}
+	t.proto = ps.Intermediate
+}
+
+// Reduce implements the MapReduceJob interface
+func (t *TypedReduce[K, V]) Reduce(key string, values []string, emitter Emitter) {
+
+	t.once.Do(t.resolve)
+
+	// without a protocol the values can't be unmarshaled, so drop them
+	if t.proto == nil {
+		IncrCounter("dmrgo", "typed reduce errors", 1)
+		return
+	}
+
+	var k K
+	var vs []V
+	t.proto.UnmarshalKVs(key, values, &k, &vs)
+
+	t.Fn(k, vs, emitter)
+}
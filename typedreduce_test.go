@@ -0,0 +1,83 @@
+package dmrgo
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// typedCount is a word count whose Reduce gets its counts as ints
+type typedCount struct {
+	wordJob
+	TypedReduce[string, int]
+}
+
+func newTypedCount() *typedCount {
+	c := new(typedCount)
+	c.Fn = func(word string, counts []int, emitter Emitter) {
+		var sum int
+		for _, n := range counts {
+			sum += n
+		}
+		emitter.Emit(word, strconv.Itoa(sum))
+	}
+	return c
+}
+
+func (c *typedCount) Reduce(key string, values []string, emitter Emitter) {
+	c.TypedReduce.Reduce(key, values, emitter)
+}
+
+func TestTypedReduce(t *testing.T) {
+
+	quietStderr(t)
+
+	// the intermediate protocol is JSON by default, so keys are quoted
+	c := newTypedCount()
+
+	var e SliceEmitter
+	c.Reduce(`"gopher"`, []string{"1", "2", "3"}, &e)
+
+	if want := []KeyValue{{"gopher", "6"}}; !reflect.DeepEqual(e.KeyValues, want) {
+		t.Errorf("reduced %v, want %v", e.KeyValues, want)
+	}
+
+	// the protocol is chosen once, however many reducers share the job
+	setFlag(t, "intermediate-proto", "tsv")
+
+	c = newTypedCount()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var s SliceEmitter
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var e SliceEmitter
+			c.Reduce("gopher", []string{"1", "2"}, &e)
+			mu.Lock()
+			s.KeyValues = append(s.KeyValues, e.KeyValues...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for _, kv := range s.KeyValues {
+		if kv != (KeyValue{"gopher", "3"}) {
+			t.Errorf("concurrent reduce gave %v, want gopher 3", kv)
+		}
+	}
+
+	// without a protocol, the values are dropped and counted
+	setFlag(t, "intermediate-proto", "no-such-protocol")
+	resetCounters()
+
+	c = newTypedCount()
+	e = SliceEmitter{}
+	c.Reduce("gopher", []string{"1"}, &e)
+
+	if len(e.KeyValues) != 0 || counterTotals()["dmrgo"]["typed reduce errors"] != 1 {
+		t.Errorf("with an unknown protocol, reduced %v and counted %d errors", e.KeyValues, counterTotals()["dmrgo"]["typed reduce errors"])
+	}
+}